@@ -0,0 +1,45 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net/http"
+	"strings"
+)
+
+// bearerProtocolPrefix is the prefix of a subprotocol that carries a bearer
+// token, for the browsers that cannot set the Authorization header.
+const bearerProtocolPrefix = "bearer."
+
+// BearerToken returns the bearer token of the handshake request. The token
+// is taken from the Authorization header, the access_token query parameter,
+// or a "bearer.<token>" subprotocol, in that order.
+//
+// A browser fails the handshake when the server selects none of the
+// subprotocols it requested, so a client sending the token as a subprotocol
+// should also request one of the Subprotocols of the Upgrader. The token
+// subprotocol itself is never selected.
+func BearerToken(r *http.Request) (token string, ok bool) {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:]), true
+	}
+	if token = r.URL.Query().Get("access_token"); token != "" {
+		return token, true
+	}
+	for _, protocol := range subprotocols(r) {
+		if strings.HasPrefix(protocol, bearerProtocolPrefix) && len(protocol) > len(bearerProtocolPrefix) {
+			return protocol[len(bearerProtocolPrefix):], true
+		}
+	}
+	return "", false
+}
+
+// Principal returns the principal returned by the Authenticate hook of the
+// Upgrader, or nil.
+func (c *Conn) Principal() interface{} {
+	if x := c.loadExtra(); x != nil {
+		return x.principal
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	u := Upgrader{
+		Subprotocols: []string{"chat"},
+		Authenticate: func(r *http.Request) (interface{}, int, http.Header) {
+			if token, ok := BearerToken(r); ok && token == "secret" {
+				return "alice", 0, http.Header{"Set-Cookie": {"session=1"}}
+			}
+			return nil, http.StatusUnauthorized, http.Header{"Www-Authenticate": {`Bearer realm="websocket"`}}
+		},
+	}
+	l, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			ws, err := u.Upgrade(conn)
+			if err != nil {
+				continue
+			}
+			if ws.Principal() != "alice" {
+				t.Error(ws.Principal())
+			}
+			ws.Close()
+		}
+	}()
+	dial := func(path string, protocols ...string) (resp *http.Response, conn *Conn, err error) {
+		trace := &ClientTrace{GotHandshakeResponse: func(r *http.Response, info TraceInfo) {
+			resp = r
+		}}
+		d := Dialer{Subprotocols: protocols}
+		conn, err = d.DialContext(WithClientTrace(context.Background(), trace), "tcp", ":8080", path)
+		return
+	}
+	resp, _, err := dial("/")
+	if err == nil {
+		t.Error()
+	}
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") != `Bearer realm="websocket"` {
+		t.Error(resp.Status, resp.Header)
+	}
+	resp, conn, err := dial("/?access_token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Set-Cookie") != "session=1" {
+		t.Error(resp.Header)
+	}
+	conn.Close()
+	_, conn, err = dial("/", "bearer.secret", "chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.Subprotocol() != "chat" {
+		t.Error(conn.Subprotocol())
+	}
+	conn.Close()
+	l.Close()
+	wg.Wait()
+}
+
+func TestBearerToken(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	if _, ok := BearerToken(r); ok {
+		t.Error()
+	}
+	r.Header.Set("Authorization", "bearer secret")
+	if token, ok := BearerToken(r); !ok || token != "secret" {
+		t.Error(token)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+)
+
+// ErrNotSupported is returned when a value can not be marshaled or unmarshaled.
+var ErrNotSupported = errors.New("not supported")
+
+// Codec represents a codec that marshals values as messages.
+type Codec interface {
+	// Marshal returns the encoding of v.
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal parses the encoded data and stores the result in v.
+	Unmarshal(data []byte, v interface{}) error
+	// MessageType returns the type of the messages, TextFrame or BinaryFrame.
+	// The values are not sent with another type, and ErrMessageType is
+	// returned instead.
+	MessageType() int
+}
+
+// JSONCodec is the Codec that marshals values as JSON text messages.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) MessageType() int {
+	return TextFrame
+}
+
+// SetCodec sets the codec of SendMessage and ReceiveMessage for the values
+// other than strings, byte slices and the values implementing the encoding
+// marshaler interfaces. It should be called before the connection is used.
+func (c *Conn) SetCodec(codec Codec) {
+	c.codec = codec
+}
+
+// WriteJSON writes the JSON encoding of v as a text message to ws.
+func (c *Conn) WriteJSON(v interface{}) error {
+	p, err := JSONCodec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(TextFrame, p)
+}
+
+// ReadJSON reads the next message from ws and stores its JSON decoding in v.
+func (c *Conn) ReadJSON(v interface{}) (err error) {
+	c.reading.Lock()
+	c.connBuffer = c.connBuffer[:0]
+	var p []byte
+	_, p, err = c.readMessage(nil)
+	c.reading.Unlock()
+	if err == nil {
+		err = JSONCodec.Unmarshal(p, v)
+	}
+	return
+}
+
+func (c *Conn) marshal(v interface{}) (opcode byte, p []byte, err error) {
+	if m, ok := v.(encoding.BinaryMarshaler); ok {
+		p, err = m.MarshalBinary()
+		return BinaryFrame, p, err
+	}
+	if m, ok := v.(encoding.TextMarshaler); ok {
+		p, err = m.MarshalText()
+		return TextFrame, p, err
+	}
+	if codec := c.codec; codec != nil {
+		messageType := codec.MessageType()
+		if messageType != TextFrame && messageType != BinaryFrame {
+			return 0, nil, ErrMessageType
+		}
+		p, err = codec.Marshal(v)
+		return byte(messageType), p, err
+	}
+	return 0, nil, ErrNotSupported
+}
+
+func (c *Conn) unmarshal(opcode byte, p []byte, v interface{}) error {
+	text, isText := v.(encoding.TextUnmarshaler)
+	binary, isBinary := v.(encoding.BinaryUnmarshaler)
+	if isText && (opcode == TextFrame || !isBinary) {
+		return text.UnmarshalText(p)
+	}
+	if isBinary {
+		return binary.UnmarshalBinary(p)
+	}
+	if c.codec != nil {
+		return c.codec.Unmarshal(p, v)
+	}
+	return ErrNotSupported
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testTextObject struct {
+	Text string
+}
+
+func (o *testTextObject) MarshalText() ([]byte, error) {
+	return []byte(o.Text), nil
+}
+
+func (o *testTextObject) UnmarshalText(text []byte) error {
+	o.Text = strings.ToUpper(string(text))
+	return nil
+}
+
+type testBinaryObject struct {
+	Data []byte
+}
+
+func (o *testBinaryObject) MarshalBinary() ([]byte, error) {
+	return o.Data, nil
+}
+
+func (o *testBinaryObject) UnmarshalBinary(data []byte) error {
+	o.Data = append(o.Data[:0], data...)
+	return nil
+}
+
+type testObject struct {
+	A int
+	B string
+}
+
+func TestCodec(t *testing.T) {
+	network := "tcp"
+	addr := ":8080"
+	Serve := func(conn *Conn) {
+		for {
+			messageType, msg, err := conn.ReadMessageType(nil)
+			if err != nil {
+				break
+			}
+			conn.WriteMessageType(messageType, msg)
+		}
+		conn.Close()
+	}
+
+	httpServer := &http.Server{
+		Addr:    addr,
+		Handler: Handler(Serve),
+	}
+	l, _ := net.Listen(network, addr)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		httpServer.Serve(l)
+	}()
+	conn, err := Dial(network, addr, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	{
+		if err := conn.WriteJSON(&testObject{A: 1, B: "Hello"}); err != nil {
+			t.Error(err)
+		}
+		var v testObject
+		if err := conn.ReadJSON(&v); err != nil {
+			t.Error(err)
+		} else if v.A != 1 || v.B != "Hello" {
+			t.Error(v)
+		}
+	}
+	{
+		if err := conn.SendMessage(&testObject{A: 1}); err != ErrNotSupported {
+			t.Error(err)
+		}
+		conn.SetCodec(JSONCodec)
+		if err := conn.SendMessage(&testObject{A: 2, B: "World"}); err != nil {
+			t.Error(err)
+		}
+		var v testObject
+		if err := conn.ReceiveMessage(&v); err != nil {
+			t.Error(err)
+		} else if v.A != 2 || v.B != "World" {
+			t.Error(v)
+		}
+	}
+	{
+		if err := conn.SendMessage(&testTextObject{Text: "hello"}); err != nil {
+			t.Error(err)
+		}
+		var v testTextObject
+		if err := conn.ReceiveMessage(&v); err != nil {
+			t.Error(err)
+		} else if v.Text != "HELLO" {
+			t.Error(v.Text)
+		}
+	}
+	{
+		if err := conn.SendMessage(&testBinaryObject{Data: []byte{1, 2, 3}}); err != nil {
+			t.Error(err)
+		}
+		var v testBinaryObject
+		if err := conn.ReceiveMessage(&v); err != nil {
+			t.Error(err)
+		} else if string(v.Data) != string([]byte{1, 2, 3}) {
+			t.Error(v.Data)
+		}
+	}
+	conn.Close()
+	httpServer.Close()
+	wg.Wait()
+}
+
+type testPingCodec struct {
+	Codec
+}
+
+func (testPingCodec) MessageType() int {
+	return PingFrame
+}
+
+func TestCodecMessageType(t *testing.T) {
+	discard := &testDiscardConn{}
+	conn := server(discard, "")
+	conn.SetCodec(testPingCodec{JSONCodec})
+	if err := conn.SendMessage(&testObject{A: 1, B: "b"}); err != ErrMessageType {
+		t.Error(err)
+	}
+	if discard.written > 0 {
+		t.Error(discard.written)
+	}
+}
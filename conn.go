@@ -4,15 +4,16 @@
 package websocket
 
 import (
-	"github.com/hslam/buffer"
-	"github.com/hslam/writer"
+	"context"
 	"io"
-	"math/rand"
 	"net"
+	"net/http"
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
+	"unsafe"
 )
 
 var gcing int32
@@ -28,28 +29,90 @@ func gc() {
 }
 
 // Conn represents a WebSocket connection.
+//
+// The read and write buffers are taken from a pool when a read or write
+// starts and released as soon as it completes. Only the bytes of a partial
+// frame are kept between messages, in a spill buffer of 256 bytes. The state
+// of the optional features, such as the context, the deadlines, the keepalive,
+// the rate limit and the send queue, is allocated when one of them is used.
+// On 64-bit platforms, an idle Conn upgraded by Upgrade takes about 1.8KB of
+// heap beside the underlying net.Conn, 1.1KB of which is the handshake request
+// kept by Request.
 type Conn struct {
-	reading         sync.Mutex
-	writing         sync.Mutex
-	isClient        bool
-	random          *rand.Rand
-	conn            net.Conn
-	writer          io.Writer
-	key             string
-	accept          string
-	path            string
-	address         string
-	shared          bool
-	scheduling      bool
-	readBufferSize  int
-	readBuffer      []byte
-	writeBufferSize int
-	writeBuffer     []byte
-	buffer          []byte
-	connBuffer      []byte
-	readPool        *buffer.Pool
-	writePool       *buffer.Pool
-	closed          int32
+	stats          counters
+	reading        sync.Mutex
+	writing        sync.Mutex
+	isClient       bool
+	borrowing      bool
+	cutOff         bool
+	fragmented     bool
+	fragmentOpcode byte
+	conn           net.Conn
+	writer         io.Writer
+	key            string
+	accept         string
+	path           string
+	address        string
+	readBufferSize int
+	maxMessageSize int
+	readBuffer     []byte
+	buffer         []byte
+	spill          []byte
+	decoder        decoder
+	connBuffer     []byte
+	codec          Codec
+	pool           BufferPool
+	request        *http.Request
+	subprotocol    string
+	onClose        func()
+	extra          unsafe.Pointer
+	nonBlocking    int32
+	closeSent      int32
+	closed         int32
+}
+
+// connExtra holds the rarely used state of a connection, which is allocated
+// by the first feature that needs it.
+type connExtra struct {
+	lock           sync.Mutex
+	err            error
+	trace          *connTrace
+	logger         Logger
+	rawConn        syscall.RawConn
+	subprotocols   []string
+	principal      interface{}
+	responseHeader http.Header
+	parent         context.Context
+	ctx            context.Context
+	cancel         context.CancelFunc
+	userData       atomic.Value
+	readDeadline   atomic.Value
+	writeDeadline  atomic.Value
+	keepalive      atomic.Value
+	rateLimit      atomic.Value
+	readContext    context.Context
+	pinger         *pinger
+	sendQueue      *sendQueue
+	fragments      []byte
+}
+
+// getExtra returns the rarely used state of the connection, and allocates it
+// if needed.
+func (c *Conn) getExtra() *connExtra {
+	if x := c.loadExtra(); x != nil {
+		return x
+	}
+	x := &connExtra{}
+	if atomic.CompareAndSwapPointer(&c.extra, nil, unsafe.Pointer(x)) {
+		return x
+	}
+	return c.loadExtra()
+}
+
+// loadExtra returns the rarely used state of the connection, or nil if it has
+// not been allocated.
+func (c *Conn) loadExtra() *connExtra {
+	return (*connExtra)(atomic.LoadPointer(&c.extra))
 }
 
 // Read implements the net.Conn Read method.
@@ -71,7 +134,7 @@ func (c *Conn) Read(b []byte) (n int, err error) {
 		c.reading.Unlock()
 		return
 	}
-	f, err := c.readFrame(nil)
+	f, err := c.nextFrame(nil)
 	if err == nil {
 		length := len(f.PayloadData)
 		if len(b) >= length {
@@ -89,6 +152,9 @@ func (c *Conn) Read(b []byte) (n int, err error) {
 }
 
 func (c *Conn) read(b []byte) (n int, err error) {
+	if atomic.LoadInt32(&c.nonBlocking) == 1 {
+		return c.readNonBlocking(b)
+	}
 	return c.conn.Read(b)
 }
 
@@ -119,13 +185,17 @@ func (c *Conn) Close() error {
 	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
 		return nil
 	}
-	if w, ok := c.writer.(*writer.Writer); ok {
+	c.stopKeepalive()
+	c.stopPinger()
+	c.stopSendQueue()
+	c.closeStats()
+	c.cancelContext()
+	if c.onClose != nil {
+		c.onClose()
+	}
+	if w, ok := c.writer.(flushCloser); ok {
 		w.Close()
 	}
-	c.readBuffer = nil
-	c.writeBuffer = nil
-	c.buffer = nil
-	c.connBuffer = nil
 	go gc()
 	return c.conn.Close()
 }
@@ -146,15 +216,20 @@ func (c *Conn) RemoteAddr() net.Addr {
 
 // SetDeadline implements the Conn SetDeadline method.
 func (c *Conn) SetDeadline(t time.Time) error {
+	x := c.getExtra()
+	x.readDeadline.Store(t)
+	x.writeDeadline.Store(t)
 	return c.conn.SetDeadline(t)
 }
 
 // SetReadDeadline implements the Conn SetReadDeadline method.
 func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.getExtra().readDeadline.Store(t)
 	return c.conn.SetReadDeadline(t)
 }
 
 // SetWriteDeadline implements the Conn SetWriteDeadline method.
 func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.getExtra().writeDeadline.Store(t)
 	return c.conn.SetWriteDeadline(t)
 }
@@ -6,9 +6,11 @@ package websocket
 import (
 	"net"
 	"net/http"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
+	"unsafe"
 )
 
 func TestConn(t *testing.T) {
@@ -110,3 +112,118 @@ func TestConn(t *testing.T) {
 	httpServer.Close()
 	wg.Wait()
 }
+
+func TestConnIdleBuffers(t *testing.T) {
+	network := "tcp"
+	addr := ":8080"
+	Serve := func(conn *Conn) {
+		for {
+			msg, err := conn.ReadMessage(nil)
+			if err != nil {
+				break
+			}
+			conn.WriteMessage(msg)
+		}
+		conn.Close()
+	}
+
+	httpServer := &http.Server{
+		Addr:    addr,
+		Handler: Handler(Serve),
+	}
+	l, _ := net.Listen(network, addr)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		httpServer.Serve(l)
+	}()
+	conn, err := Dial(network, addr, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	msgs := []string{"Hello", "World"}
+	for _, msg := range msgs {
+		f := &frame{FIN: 1, Opcode: BinaryFrame, Mask: 1, MaskingKey: []byte{1, 2, 3, 4}, PayloadData: []byte(msg)}
+		b, _ := f.Marshal(nil)
+		data = append(data, b...)
+	}
+	if _, err := conn.conn.Write(data); err != nil {
+		t.Error(err)
+	}
+	for _, msg := range msgs {
+		p, err := conn.ReadMessage(nil)
+		if err != nil {
+			t.Error(err)
+		} else if string(p) != msg {
+			t.Error(string(p))
+		}
+	}
+	big := make([]byte, bufferSize*3)
+	if err := conn.WriteMessage(big); err != nil {
+		t.Error(err)
+	}
+	if p, err := conn.ReadMessage(nil); err != nil {
+		t.Error(err)
+	} else if len(p) != len(big) {
+		t.Error(len(p))
+	}
+	if conn.readBuffer != nil || cap(conn.buffer) > spillSize {
+		t.Error(cap(conn.readBuffer), cap(conn.buffer))
+	}
+	conn.Close()
+	httpServer.Close()
+	wg.Wait()
+}
+
+type testIdleConn struct {
+	testSegmentConn
+}
+
+func (c *testIdleConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+}
+
+func TestConnSize(t *testing.T) {
+	if unsafe.Sizeof(uintptr(0)) != 8 || testing.Short() {
+		return
+	}
+	request := "GET / HTTP/1.1\r\n" +
+		"Host: localhost:8080\r\n" +
+		"Origin: *\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"
+	const n = 1000
+	netConns := make([]*testIdleConn, n)
+	for i := range netConns {
+		netConns[i] = &testIdleConn{testSegmentConn{data: []byte(request), segment: len(request)}}
+		netConns[i].written.Grow(256)
+	}
+	conns := make([]*Conn, n)
+	var before, after runtime.MemStats
+	// The second collection frees the buffers left in the pools.
+	runtime.GC()
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for i := range conns {
+		conn, err := Upgrade(netConns[i], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn.loadExtra() != nil {
+			t.Error("the optional state has been allocated")
+		}
+		conns[i] = conn
+	}
+	runtime.GC()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	if size := (after.HeapAlloc - before.HeapAlloc) / n; size > 2048 {
+		t.Errorf("the documented footprint is about 1.8KB, but an idle Conn takes %d bytes", size)
+	}
+	runtime.KeepAlive(conns)
+	runtime.KeepAlive(netConns)
+}
@@ -0,0 +1,256 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// valuesContext is a context with the values of its parent, that is never
+// cancelled and has no deadline.
+type valuesContext struct {
+	context.Context
+}
+
+func (valuesContext) Deadline() (deadline time.Time, ok bool) {
+	return
+}
+
+func (valuesContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (valuesContext) Err() error {
+	return nil
+}
+
+// Context returns the context of the connection, which is cancelled when the
+// connection is closed. It has the values of the context of the upgrade
+// request or of the dial, but not their deadline nor their cancellation.
+func (c *Conn) Context() context.Context {
+	x := c.getExtra()
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if x.ctx == nil {
+		parent := x.parent
+		if parent == nil && c.request != nil {
+			parent = c.request.Context()
+		}
+		if parent == nil {
+			parent = context.Background()
+		}
+		x.ctx, x.cancel = context.WithCancel(parent)
+		if atomic.LoadInt32(&c.closed) == 1 {
+			x.cancel()
+		}
+	}
+	return x.ctx
+}
+
+// cancelContext cancels the context of the closed connection.
+func (c *Conn) cancelContext() {
+	x := c.loadExtra()
+	if x == nil {
+		return
+	}
+	x.lock.Lock()
+	if x.cancel != nil {
+		x.cancel()
+	}
+	x.lock.Unlock()
+}
+
+type userData struct {
+	value interface{}
+}
+
+// SetUserData sets the session data of the connection, such as the state of
+// an event loop handler. It is safe to call it concurrently with UserData.
+func (c *Conn) SetUserData(value interface{}) {
+	c.getExtra().userData.Store(userData{value})
+}
+
+// UserData returns the session data set by SetUserData, or nil.
+func (c *Conn) UserData() interface{} {
+	x := c.loadExtra()
+	if x == nil {
+		return nil
+	}
+	data, _ := x.userData.Load().(userData)
+	return data.value
+}
+
+// aLongTimeAgo is a deadline in the past that interrupts the blocked reads
+// and writes.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// watch interrupts the read or the write of the caller, which holds the
+// reading or the writing lock, when ctx is done, by setting a deadline in the
+// past. The returned function stops watching, restores the deadline set by
+// the user of the connection, and reports whether ctx has been done.
+func (c *Conn) watch(ctx context.Context, write bool) (stop func() bool) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	var fired bool
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			fired = true
+			if write {
+				c.conn.SetWriteDeadline(aLongTimeAgo)
+			} else {
+				c.conn.SetReadDeadline(aLongTimeAgo)
+			}
+		case <-done:
+		}
+	}()
+	return func() bool {
+		close(done)
+		<-stopped
+		if fired {
+			var deadline time.Time
+			x := c.loadExtra()
+			if write {
+				if x != nil {
+					deadline, _ = x.writeDeadline.Load().(time.Time)
+				}
+				c.conn.SetWriteDeadline(deadline)
+			} else {
+				if x != nil {
+					deadline, _ = x.readDeadline.Load().(time.Time)
+				}
+				c.conn.SetReadDeadline(deadline)
+			}
+		}
+		return fired
+	}
+}
+
+// interrupted reports whether err has been caused by the deadline set by
+// watch.
+func interrupted(err error) bool {
+	e, ok := err.(net.Error)
+	return ok && e.Timeout()
+}
+
+// readMessageContext reads a message with the context. The deadline is only
+// set once the reading lock is held, so that the cancellation of a waiting
+// read does not interrupt the read in progress. An interrupted read leaves
+// the partial frame in the connection, to be completed by the next read.
+func (c *Conn) readMessageContext(ctx context.Context, buf []byte) (opcode byte, p []byte, err error) {
+	c.reading.Lock()
+	defer c.reading.Unlock()
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	c.connBuffer = c.connBuffer[:0]
+	if ctx.Done() == nil {
+		return c.readMessage(buf)
+	}
+	if x := c.loadExtra(); x != nil {
+		// The delay of the rate limit is interrupted by ctx.
+		x.readContext = ctx
+		defer func() { x.readContext = nil }()
+	}
+	stop := c.watch(ctx, false)
+	opcode, p, err = c.readMessage(buf)
+	if stop() && err != nil && interrupted(err) {
+		return 0, nil, ctx.Err()
+	}
+	return
+}
+
+// writeMessageContext writes a message with the context. The deadline is
+// only set once the writing lock is held, so that the cancellation of a
+// waiting write does not interrupt the write in progress. The connection is
+// only closed when an interrupted write has cut off a frame, or when it is a
+// TLS connection, whose writes are broken for good by a timeout.
+func (c *Conn) writeMessageContext(ctx context.Context, opcode byte, b []byte) (err error) {
+	if len(b) == 0 {
+		return ctx.Err()
+	}
+	c.writing.Lock()
+	defer c.writing.Unlock()
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	f := c.getFrame()
+	f.FIN = 1
+	f.Opcode = opcode
+	f.PayloadData = b
+	if ctx.Done() == nil {
+		return c.writeFrame(f)
+	}
+	stop := c.watch(ctx, true)
+	err = c.writeFrame(f)
+	if stop() && err != nil && interrupted(err) {
+		if _, ok := c.conn.(*tls.Conn); ok || c.cutOff {
+			c.fail(ctx.Err())
+		}
+		return ctx.Err()
+	}
+	return
+}
+
+// ReadMessageContext is like ReadMessage, but returns the error of ctx when
+// it is done before a message has been read. The partial message is kept by
+// the connection and completed by the next read.
+func (c *Conn) ReadMessageContext(ctx context.Context, buf []byte) (p []byte, err error) {
+	_, p, err = c.readMessageContext(ctx, buf)
+	return
+}
+
+// WriteMessageContext is like WriteMessage, but returns the error of ctx when
+// it is done before the message has been written. If the frame has been cut
+// off, the connection is then closed, as the peer can no longer parse the
+// stream. A TLS connection is closed by any interrupted write, even if no
+// byte has been written, as crypto/tls fails all the writes that follow a
+// write timeout.
+func (c *Conn) WriteMessageContext(ctx context.Context, b []byte) error {
+	return c.writeMessageContext(ctx, BinaryFrame, b)
+}
+
+// ReceiveMessageContext is like ReceiveMessage with the context, like
+// ReadMessageContext.
+func (c *Conn) ReceiveMessageContext(ctx context.Context, v interface{}) error {
+	opcode, p, err := c.readMessageContext(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return c.decode(opcode, p, v)
+}
+
+// SendMessageContext is like SendMessage with the context, like
+// WriteMessageContext.
+func (c *Conn) SendMessageContext(ctx context.Context, v interface{}) error {
+	opcode, p, err := c.encode(v)
+	if err != nil {
+		return err
+	}
+	return c.writeMessageContext(ctx, opcode, p)
+}
+
+// ReadJSONContext is like ReadJSON with the context, like ReadMessageContext.
+func (c *Conn) ReadJSONContext(ctx context.Context, v interface{}) error {
+	_, p, err := c.readMessageContext(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return JSONCodec.Unmarshal(p, v)
+}
+
+// WriteJSONContext is like WriteJSON with the context, like
+// WriteMessageContext.
+func (c *Conn) WriteJSONContext(ctx context.Context, v interface{}) error {
+	p, err := JSONCodec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeMessageContext(ctx, TextFrame, p)
+}
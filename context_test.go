@@ -0,0 +1,281 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testContextKey struct{}
+
+func TestConnContext(t *testing.T) {
+	conns := make(chan *Conn, 1)
+	httpServer := &http.Server{
+		Addr: ":8080",
+		Handler: Handler(func(conn *Conn) {
+			conns <- conn
+		}),
+	}
+	l, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		httpServer.Serve(l)
+	}()
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), testContextKey{}, "value"))
+	var d Dialer
+	conn, err := d.DialContext(ctx, "tcp", ":8080", "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if conn.Context().Value(testContextKey{}) != "value" || conn.Context().Err() != nil {
+		t.Error(conn.Context().Err())
+	}
+	ws := <-conns
+	if ws.Context().Value(http.ServerContextKey) != httpServer {
+		t.Error()
+	}
+	ws.SetUserData(1)
+	ws.SetUserData("session")
+	if ws.UserData() != "session" {
+		t.Error(ws.UserData())
+	}
+	time.Sleep(time.Millisecond * 10)
+	select {
+	case <-ws.Context().Done():
+		t.Error(ws.Context().Err())
+	default:
+	}
+	ws.Close()
+	select {
+	case <-ws.Context().Done():
+	case <-time.After(time.Second):
+		t.Error()
+	}
+	conn.Close()
+	if conn.Context().Err() != context.Canceled {
+		t.Error(conn.Context().Err())
+	}
+	httpServer.Close()
+	wg.Wait()
+}
+
+func TestReadWriteContext(t *testing.T) {
+	l, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ws, err := Upgrade(conn, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer ws.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		if _, err := ws.ReadMessageContext(ctx, nil); err != context.DeadlineExceeded {
+			t.Error(err)
+		}
+		msg, err := ws.ReadMessageContext(context.Background(), nil)
+		if err != nil {
+			t.Error(err)
+		} else if string(msg) != "Hello World" {
+			t.Error(string(msg))
+		}
+		if err := ws.SendMessageContext(context.Background(), string(msg)); err != nil {
+			t.Error(err)
+		}
+		ws.ReadMessage(nil)
+	}()
+	conn, err := Dial("tcp", ":8080", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := conn.WriteMessageContext(ctx, []byte("Hello")); err != context.Canceled {
+		t.Error(err)
+	}
+	if err := conn.WriteMessageContext(context.Background(), []byte("Hello World")); err != nil {
+		t.Error(err)
+	}
+	var msg string
+	if err := conn.ReceiveMessageContext(context.Background(), &msg); err != nil {
+		t.Error(err)
+	} else if msg != "Hello World" {
+		t.Error(msg)
+	}
+	conn.Close()
+	wg.Wait()
+	l.Close()
+}
+
+func TestConcurrentContext(t *testing.T) {
+	l, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := make(chan *Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ws, err := Upgrade(conn, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		servers <- ws
+	}()
+	conn, err := Dial("tcp", ":8080", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-servers
+	l.Close()
+	large := make([]byte, 16<<20)
+
+	// The cancellation of a waiting writer does not cut off the frame in
+	// progress, nor close the connection.
+	first := make(chan error, 1)
+	go func() {
+		first <- server.WriteMessage(large)
+	}()
+	time.Sleep(time.Millisecond * 50)
+	ctx, cancel := context.WithCancel(context.Background())
+	second := make(chan error, 1)
+	go func() {
+		second <- server.WriteMessageContext(ctx, []byte("cancelled"))
+	}()
+	time.Sleep(time.Millisecond * 50)
+	cancel()
+	time.Sleep(time.Millisecond * 50)
+	if msg, err := conn.ReadMessage(nil); err != nil || len(msg) != len(large) {
+		t.Fatal(len(msg), err)
+	}
+	if err := <-first; err != nil {
+		t.Error(err)
+	}
+	if err := <-second; err != context.Canceled {
+		t.Error(err)
+	}
+	server.WriteMessage([]byte("Hello"))
+	if msg, err := conn.ReadMessage(nil); err != nil || string(msg) != "Hello" {
+		t.Error(string(msg), err)
+	}
+
+	// The cancellation of a waiting reader does not interrupt the read in
+	// progress.
+	read := make(chan error, 1)
+	go func() {
+		msg, err := server.ReadMessage(nil)
+		if err == nil && string(msg) != "World" {
+			t.Error(string(msg))
+		}
+		read <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	ctx, cancel = context.WithCancel(context.Background())
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := server.ReadMessageContext(ctx, nil)
+		waiting <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	cancel()
+	time.Sleep(time.Millisecond * 50)
+	conn.WriteMessage([]byte("World"))
+	if err := <-read; err != nil {
+		t.Error(err)
+	}
+	if err := <-waiting; err != context.Canceled {
+		t.Error(err)
+	}
+
+	// A write interrupted in the middle of a frame closes the connection.
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err := server.WriteMessageContext(ctx, large); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+	if err := server.WriteMessage([]byte("closed")); err == nil {
+		t.Error()
+	}
+	conn.Close()
+	server.Close()
+}
+
+// testTimeoutConn fails the writes that follow a write timeout, so that the
+// close_notify alert of a TLS connection is not blocked.
+type testTimeoutConn struct {
+	net.Conn
+	timedOut bool
+}
+
+func (c *testTimeoutConn) Write(b []byte) (n int, err error) {
+	if c.timedOut {
+		return 0, io.ErrClosedPipe
+	}
+	n, err = c.Conn.Write(b)
+	if interrupted(err) {
+		c.timedOut = true
+	}
+	return
+}
+
+func TestWriteContextTLS(t *testing.T) {
+	pipe, serverConn := net.Pipe()
+	clientConn := &testTimeoutConn{Conn: pipe}
+	tlsServer := tls.Server(serverConn, testServerTLSConfig())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- tlsServer.Handshake()
+	}()
+	tlsClient := tls.Client(clientConn, testSkipVerifyTLSConfig())
+	if err := tlsClient.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	// The server does not read, so the write is interrupted before any byte
+	// has been written.
+	conn := client(tlsClient, "", "/")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err := conn.WriteMessageContext(ctx, []byte("Hello World")); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+	if conn.cutOff {
+		t.Error("the frame has been cut off")
+	}
+	if err := conn.failure(); err != context.DeadlineExceeded {
+		t.Error("the connection has not been closed", err)
+	}
+	tlsServer.Close()
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Close codes defined in RFC 6455, section 11.7.
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+)
+
+const maxControlPayloadSize = 125
+
+// ErrProtocol is returned when the peer violates the WebSocket protocol.
+var ErrProtocol = errors.New("protocol error")
+
+// CloseError represents a close frame received from the peer.
+type CloseError struct {
+	// Code is the status code of the close frame.
+	Code int
+	// Text is the reason of the close frame.
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	s := "close " + strconv.Itoa(e.Code)
+	if e.Text != "" {
+		s += ": " + e.Text
+	}
+	return s
+}
+
+// nextFrame reads the next data frame, and handles the control frames that
+// arrive before it.
+func (c *Conn) nextFrame(buf []byte) (f *frame, err error) {
+	for {
+		f, err = c.readFrame(buf)
+		if err != nil {
+			return nil, err
+		}
+		if f.RSV1|f.RSV2|f.RSV3 != 0 {
+			c.putFrame(f)
+			return nil, c.protocolError("reserved bits are set")
+		}
+		if f.Opcode < CloseFrame {
+			if f.Opcode > BinaryFrame {
+				c.putFrame(f)
+				return nil, c.protocolError("unknown opcode " + strconv.Itoa(int(f.Opcode)))
+			}
+			c.readData()
+			return f, nil
+		}
+		if f.FIN == 0 || len(f.PayloadData) > maxControlPayloadSize {
+			c.putFrame(f)
+			return nil, c.protocolError("invalid control frame")
+		}
+		switch f.Opcode {
+		case PingFrame:
+			c.readControl()
+			err = c.writeControl(PongFrame, f.PayloadData)
+		case PongFrame:
+			c.readControl()
+			c.pong(f.PayloadData)
+		case CloseFrame:
+			err = c.readClose(f.PayloadData)
+		default:
+			err = c.protocolError("unknown opcode " + strconv.Itoa(int(f.Opcode)))
+		}
+		c.putPayload(f)
+		c.putFrame(f)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readClose replies to the close frame of the peer if the connection has not
+// sent one, and closes the connection.
+func (c *Conn) readClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	if len(payload) == 1 {
+		return c.protocolError("invalid close frame")
+	} else if len(payload) >= 2 {
+		closeErr.Code = int(payload[0])<<8 | int(payload[1])
+		closeErr.Text = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.protocolError("invalid close code " + strconv.Itoa(closeErr.Code))
+		}
+		if !utf8.ValidString(closeErr.Text) {
+			return c.protocolError("invalid close reason")
+		}
+	}
+	c.closeCodeStats(closeErr.Code, true)
+	c.traceCloseReceived(closeErr.Code, closeErr.Text)
+	if closeErr.Code != CloseNormalClosure && closeErr.Code != CloseGoingAway && closeErr.Code != CloseNoStatusReceived {
+		if l := c.log(); l != nil {
+			l.Info("abnormal close", "code", closeErr.Code, "reason", closeErr.Text, "remote", remoteAddr(c.conn))
+		}
+	}
+	if closeErr.Code == CloseNoStatusReceived {
+		c.writeClose(CloseNoStatusReceived, "")
+	} else {
+		c.writeClose(closeErr.Code, "")
+	}
+	c.fail(closeErr)
+	return closeErr
+}
+
+// validCloseCode reports whether the status code may be sent in a close frame,
+// as in RFC 6455, section 7.4. The codes 1012 to 1014 have been registered
+// with IANA since, and the codes 3000 to 4999 are for the libraries, the
+// frameworks and the applications.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// protocolError fails the connection with the protocol error status code.
+func (c *Conn) protocolError(text string) error {
+	if l := c.log(); l != nil {
+		l.Warn("protocol error", "reason", text, "remote", remoteAddr(c.conn))
+	}
+	c.writeClose(CloseProtocolError, text)
+	c.fail(ErrProtocol)
+	return ErrProtocol
+}
+
+// messageTooBig fails the connection with the message too big status code.
+func (c *Conn) messageTooBig() error {
+	if l := c.log(); l != nil {
+		l.Warn("message too big", "limit", c.readLimit(), "remote", remoteAddr(c.conn))
+	}
+	c.writeClose(CloseMessageTooBig, ErrMessageTooBig.Error())
+	c.fail(ErrMessageTooBig)
+	return ErrMessageTooBig
+}
+
+// writeClose writes a close frame, unless one has been written.
+func (c *Conn) writeClose(code int, text string) error {
+	if !atomic.CompareAndSwapInt32(&c.closeSent, 0, 1) {
+		return nil
+	}
+	c.closeCodeStats(code, false)
+	var payload []byte
+	if code != CloseNoStatusReceived {
+		if len(text) > maxControlPayloadSize-2 {
+			text = text[:maxControlPayloadSize-2]
+		}
+		payload = make([]byte, 2+len(text))
+		payload[0] = byte(code >> 8)
+		payload[1] = byte(code)
+		copy(payload[2:], text)
+	}
+	err := c.writeControl(CloseFrame, payload)
+	if err == nil {
+		c.traceCloseSent(code, text)
+	}
+	return err
+}
+
+// writeControl writes a control frame.
+func (c *Conn) writeControl(opcode byte, payload []byte) (err error) {
+	c.writing.Lock()
+	f := c.getFrame()
+	f.FIN = 1
+	f.Opcode = opcode
+	f.PayloadData = payload
+	err = c.writeFrame(f)
+	c.writing.Unlock()
+	return
+}
+
+// fail records the reason why the connection has been closed, and closes it.
+func (c *Conn) fail(err error) {
+	x := c.getExtra()
+	x.lock.Lock()
+	if x.err == nil {
+		x.err = err
+	}
+	x.lock.Unlock()
+	c.Close()
+}
+
+// failure returns the reason why the connection has been closed.
+func (c *Conn) failure() (err error) {
+	x := c.loadExtra()
+	if x == nil {
+		return nil
+	}
+	x.lock.Lock()
+	err = x.err
+	x.lock.Unlock()
+	return
+}
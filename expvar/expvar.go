@@ -0,0 +1,24 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+// Package expvar publishes the process-wide statistics of the websocket
+// package through expvar as "websocket". It is only imported for its side
+// effects, like the expvar package registers its handler:
+//
+//	import _ "github.com/hslam/websocket/expvar"
+//
+// The variable is not published if the name is already taken.
+package expvar
+
+import (
+	"expvar"
+	"github.com/hslam/websocket"
+)
+
+func init() {
+	if expvar.Get("websocket") == nil {
+		expvar.Publish("websocket", expvar.Func(func() interface{} {
+			return websocket.GlobalStats()
+		}))
+	}
+}
@@ -0,0 +1,22 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package expvar
+
+import (
+	"encoding/json"
+	"expvar"
+	"github.com/hslam/websocket"
+	"testing"
+)
+
+func TestPublish(t *testing.T) {
+	v := expvar.Get("websocket")
+	if v == nil {
+		t.Fatal()
+	}
+	var stats websocket.Stats
+	if err := json.Unmarshal([]byte(v.String()), &stats); err != nil {
+		t.Error(err)
+	}
+}
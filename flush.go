@@ -0,0 +1,148 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"github.com/hslam/writer"
+	"io"
+	"sync"
+	"time"
+)
+
+type flushCloser interface {
+	Flush() error
+	Close() error
+}
+
+// SetAutoFlush sets the buffered output that batches the frames until Flush
+// is called, maxPendingBytes are pending, or maxDelay has elapsed since the
+// first pending frame. A zero maxDelay disables the timer, and a
+// maxPendingBytes less than 1 means the default buffer size.
+//
+// Control frames bypass the batching and flush the pending frames at once.
+func (c *Conn) SetAutoFlush(maxDelay time.Duration, maxPendingBytes int) {
+	if maxPendingBytes < 1 {
+		maxPendingBytes = bufferSize
+	}
+	c.writing.Lock()
+	if w, ok := c.writer.(flushCloser); ok {
+		w.Close()
+	}
+	c.writer = &batchWriter{
+		conn:     c.conn,
+		pool:     c.pool,
+		maxDelay: maxDelay,
+		maxBytes: maxPendingBytes,
+	}
+	c.writing.Unlock()
+}
+
+// Flush writes any buffered frames to the connection.
+func (c *Conn) Flush() (err error) {
+	c.writing.Lock()
+	err = c.flush()
+	c.writing.Unlock()
+	return
+}
+
+func (c *Conn) flush() error {
+	if w, ok := c.writer.(flushCloser); ok {
+		return w.Flush()
+	}
+	return nil
+}
+
+// batchWriter buffers the writes until it is flushed explicitly, the buffer
+// is full, or the delay has elapsed.
+type batchWriter struct {
+	lock     sync.Mutex
+	conn     io.Writer
+	pool     BufferPool
+	maxDelay time.Duration
+	maxBytes int
+	buffer   []byte
+	timer    *time.Timer
+	err      error
+	closed   bool
+}
+
+func (w *batchWriter) Write(p []byte) (n int, err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return 0, writer.ErrWriterClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if len(w.buffer)+len(p) > w.maxBytes {
+		if err = w.flush(); err != nil {
+			return 0, err
+		}
+	}
+	if len(p) >= w.maxBytes {
+		return w.conn.Write(p)
+	}
+	if w.buffer == nil {
+		w.buffer = w.pool.GetBuffer(w.maxBytes)[:0]
+		if w.maxDelay > 0 {
+			if w.timer == nil {
+				w.timer = time.AfterFunc(w.maxDelay, w.timeout)
+			} else {
+				w.timer.Reset(w.maxDelay)
+			}
+		}
+	}
+	w.buffer = append(w.buffer, p...)
+	if len(w.buffer) >= w.maxBytes {
+		err = w.flush()
+	}
+	return len(p), err
+}
+
+func (w *batchWriter) timeout() {
+	w.lock.Lock()
+	if w.buffer != nil {
+		w.err = w.flush()
+	}
+	w.lock.Unlock()
+}
+
+func (w *batchWriter) flush() (err error) {
+	if w.buffer == nil {
+		return nil
+	}
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	if len(w.buffer) > 0 {
+		_, err = w.conn.Write(w.buffer)
+	}
+	w.pool.PutBuffer(w.buffer)
+	w.buffer = nil
+	return err
+}
+
+// Flush writes any buffered data to the connection.
+func (w *batchWriter) Flush() (err error) {
+	w.lock.Lock()
+	if w.err != nil {
+		err = w.err
+	} else {
+		err = w.flush()
+	}
+	w.lock.Unlock()
+	return
+}
+
+// Close flushes the buffered data, but does not close the connection.
+func (w *batchWriter) Close() (err error) {
+	w.lock.Lock()
+	if !w.closed {
+		w.closed = true
+		err = w.flush()
+	}
+	w.lock.Unlock()
+	return
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAutoFlush(t *testing.T) {
+	network := "tcp"
+	addr := ":8080"
+	Serve := func(conn *Conn) {
+		for {
+			msg, err := conn.ReadMessage(nil)
+			if err != nil {
+				break
+			}
+			conn.WriteMessage(msg)
+		}
+		conn.Close()
+	}
+
+	httpServer := &http.Server{
+		Addr:    addr,
+		Handler: Handler(Serve),
+	}
+	l, _ := net.Listen(network, addr)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		httpServer.Serve(l)
+	}()
+	conn, err := Dial(network, addr, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := "Hello World"
+	{
+		conn.SetAutoFlush(0, 1024*1024)
+		if err := conn.WriteMessage([]byte(msg)); err != nil {
+			t.Error(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+		if _, err := conn.ReadMessage(nil); err == nil {
+			t.Error("unflushed message was echoed")
+		}
+		if err := conn.Flush(); err != nil {
+			t.Error(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if data, err := conn.ReadMessage(nil); err != nil {
+			t.Error(err)
+		} else if string(data) != msg {
+			t.Error(string(data))
+		}
+	}
+	{
+		conn.SetAutoFlush(time.Millisecond*10, 1024*1024)
+		if err := conn.WriteMessage([]byte(msg)); err != nil {
+			t.Error(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if data, err := conn.ReadMessage(nil); err != nil {
+			t.Error(err)
+		} else if string(data) != msg {
+			t.Error(string(data))
+		}
+	}
+	{
+		conn.SetAutoFlush(0, 64)
+		big := make([]byte, 1024)
+		if err := conn.WriteMessage([]byte(msg)); err != nil {
+			t.Error(err)
+		}
+		if err := conn.WriteMessage(big); err != nil {
+			t.Error(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if data, err := conn.ReadMessage(nil); err != nil {
+			t.Error(err)
+		} else if string(data) != msg {
+			t.Error(string(data))
+		}
+		if data, err := conn.ReadMessage(nil); err != nil {
+			t.Error(err)
+		} else if len(data) != len(big) {
+			t.Error(len(data))
+		}
+	}
+	{
+		conn.SetBufferedOutput(bufferSize)
+		if err := conn.WriteMessage([]byte(msg)); err != nil {
+			t.Error(err)
+		}
+		if err := conn.Flush(); err != nil {
+			t.Error(err)
+		}
+		if data, err := conn.ReadMessage(nil); err != nil {
+			t.Error(err)
+		} else if string(data) != msg {
+			t.Error(string(data))
+		}
+	}
+	conn.Close()
+	httpServer.Close()
+	wg.Wait()
+}
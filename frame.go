@@ -4,10 +4,15 @@
 package websocket
 
 import (
+	"encoding/binary"
+	"errors"
 	"io"
 	"math/rand"
+	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -28,12 +33,25 @@ const (
 const (
 	bufferSize     = 65522
 	maxHeaderBytes = 14
+	spillSize      = 256
+	// writevThreshold is the payload size from which an unmasked frame is
+	// written with vectored I/O instead of being copied.
+	writevThreshold = 4096
 )
 
 var (
 	framePool = &sync.Pool{New: func() interface{} { return &frame{} }}
 )
 
+// maxInt is the size of the messages read from a connection without limit.
+const maxInt = int(^uint(0) >> 1)
+
+// ErrFrameTooLarge is returned when the payload length of a frame overflows.
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// ErrMessageTooBig is returned when a message read exceeds the read limit.
+var ErrMessageTooBig = errors.New("message too big")
+
 func (c *Conn) getFrame() *frame {
 	return framePool.Get().(*frame)
 }
@@ -43,103 +61,412 @@ func (c *Conn) putFrame(f *frame) {
 	framePool.Put(f)
 }
 
-func (c *Conn) readFrame(buf []byte) (f *frame, err error) {
-	f = c.getFrame()
-	for {
-		length := uint64(len(c.buffer))
-		var i uint64 = 0
-		if i < length && length > 2 {
-			var offset uint64
-			offset, _ = f.Unmarshal(c.buffer)
-			if offset > 0 {
-				msgLength := len(f.PayloadData)
-				var p []byte
-				if cap(buf) >= msgLength {
-					p = buf[:msgLength]
-				} else {
-					p = make([]byte, msgLength)
-				}
-				copy(p, f.PayloadData)
-				f.PayloadData = p
-				n := copy(c.buffer, c.buffer[offset:])
-				c.buffer = c.buffer[:n]
-				return
-			}
+// decoder keeps the state of a frame that has been partially read, so that
+// a read interrupted by an error resumes where it stopped.
+type decoder struct {
+	header     [maxHeaderBytes]byte
+	headerSize int
+	parsed     bool
+	length     uint64
+	payload    []byte
+	read       int
+	owned      bool
+	pooled     bool
+}
+
+func (d *decoder) Reset() {
+	*d = decoder{}
+}
+
+// needed returns the number of header bytes needed to parse the header.
+func (d *decoder) needed() int {
+	if d.headerSize < 2 {
+		return 2
+	}
+	n := 2
+	switch d.header[1] & 0x7F {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if d.header[1]>>7 == 1 {
+		n += 4
+	}
+	return n
+}
+
+// parse parses the header, and checks the payload length against the limit
+// before anything is allocated for the payload.
+func (d *decoder) parse(limit int) error {
+	var offset = 2
+	switch length := d.header[1] & 0x7F; length {
+	case 126:
+		d.length = uint64(d.header[2])<<8 | uint64(d.header[3])
+		offset += 2
+	case 127:
+		d.length = 0
+		for i := 0; i < 8; i++ {
+			d.length = d.length<<8 | uint64(d.header[2+i])
 		}
-		var readBuffer []byte
-		if c.shared {
-			readBuffer = c.readPool.GetBuffer(c.readBufferSize)
-			readBuffer = readBuffer[:cap(readBuffer)]
-		} else {
-			readBuffer = c.readBuffer
+		if d.length>>63 == 1 {
+			return ErrFrameTooLarge
 		}
-		var n int
-		n, err = c.read(readBuffer)
-		if err != nil {
-			if c.shared {
-				c.readPool.PutBuffer(readBuffer)
+		offset += 8
+	default:
+		d.length = uint64(length)
+	}
+	if uint64(int(d.length)) != d.length {
+		return ErrFrameTooLarge
+	}
+	if d.length > uint64(limit) {
+		return ErrMessageTooBig
+	}
+	d.parsed = true
+	return nil
+}
+
+func (d *decoder) frame(f *frame) {
+	f.FIN = d.header[0] >> 7
+	f.RSV1 = d.header[0] >> 6 & 1
+	f.RSV2 = d.header[0] >> 5 & 1
+	f.RSV3 = d.header[0] >> 4 & 1
+	f.Opcode = d.header[0] & 0xF
+	f.Mask = d.header[1] >> 7
+	f.PayloadLength = d.header[1] & 0x7F
+	if f.PayloadLength > 125 {
+		f.ExtendedPayloadLength = d.length
+	}
+	f.PayloadData = d.payload[:d.length]
+	f.pooled = d.pooled
+	if f.Mask == 1 {
+		maskBytes(d.header[d.headerSize-4:d.headerSize], f.PayloadData)
+	}
+}
+
+// readFrame reads the next frame. The header is parsed once, and the payload
+// is read into buf if it is large enough, or into a slice that grows as the
+// bytes arrive, so that a peer cannot make the connection allocate a large
+// payload it does not send. A large payload is read straight from the
+// connection into its destination.
+func (c *Conn) readFrame(buf []byte) (f *frame, err error) {
+	d := &c.decoder
+	idle := c.readBuffer == nil
+	for !d.parsed {
+		if len(c.buffer) == 0 {
+			if err = c.fill(idle); err != nil {
+				return nil, c.readError(err)
 			}
-			errMsg := err.Error()
-			if strings.Contains(errMsg, "use of closed network connection") || strings.Contains(errMsg, "connection reset by peer") {
-				err = io.EOF
+			idle = false
+		}
+		n := copy(d.header[d.headerSize:d.needed()], c.buffer)
+		d.headerSize += n
+		c.buffer = c.buffer[n:]
+		if d.headerSize == d.needed() {
+			limit := c.readLimit()
+			if c.fragmented && d.header[0]&0x8 == 0 {
+				// The limit applies to the reassembled message.
+				limit -= len(c.getExtra().fragments)
 			}
-			if err == io.EOF {
+			if err = d.parse(limit); err != nil {
+				if err == ErrMessageTooBig {
+					return nil, c.messageTooBig()
+				}
 				c.Close()
+				return nil, err
 			}
-			return nil, err
-		} else if n > 0 {
-			length := len(c.buffer)
-			size := length + n
-			if cap(c.buffer) >= size {
-				c.buffer = c.buffer[:size]
-				copy(c.buffer[length:], readBuffer[:n])
-			} else {
-				c.buffer = append(c.buffer, readBuffer[:n]...)
+			if cap(buf) >= int(d.length) {
+				d.payload = buf[:d.length]
+			}
+		}
+	}
+	length := int(d.length)
+	for d.read < length {
+		if len(d.payload) == d.read {
+			c.grow(d)
+		}
+		if len(c.buffer) > 0 {
+			n := copy(d.payload[d.read:], c.buffer)
+			d.read += n
+			c.buffer = c.buffer[n:]
+			continue
+		}
+		if length-d.read >= c.readBufferSize {
+			var n int
+			n, err = c.read(d.payload[d.read:])
+			d.read += n
+			if n > 0 {
+				c.readBytes()
 			}
-			if c.shared {
-				c.readPool.PutBuffer(readBuffer)
+		} else {
+			err = c.fill(false)
+		}
+		if err != nil {
+			if !d.owned && d.payload != nil {
+				d.payload = append(make([]byte, 0, len(d.payload)), d.payload[:d.read]...)[:len(d.payload)]
+				d.owned = true
 			}
+			return nil, c.readError(err)
 		}
 	}
+	if d.payload == nil {
+		d.payload = buf[:0]
+	}
+	f = c.getFrame()
+	d.frame(f)
+	d.Reset()
+	c.release()
+	c.readFrameStats(f)
+	c.traceFrameRead(f)
+	return f, nil
 }
 
-func (c *Conn) writeFrame(f *frame) error {
-	if c.isClient {
-		f.Mask = 1
-		f.MaskingKey = maskingKey(c.random)
+// grow makes room for more bytes of the payload. The payload doubles up to
+// its declared length, from a first read buffer size, so that the memory
+// follows the bytes that have arrived.
+func (c *Conn) grow(d *decoder) {
+	size := 2 * len(d.payload)
+	if size < bufferSize {
+		size = bufferSize
+	}
+	if size > int(d.length) {
+		size = int(d.length)
 	}
-	var writeBuffer []byte
-	if c.shared {
-		writeBuffer = c.writePool.GetBuffer(c.writeBufferSize)
-		writeBuffer = writeBuffer[:cap(writeBuffer)]
+	var p []byte
+	if c.borrowing {
+		p = c.pool.GetBuffer(size)[:size]
 	} else {
-		writeBuffer = c.writeBuffer
+		p = make([]byte, size)
 	}
-	maxBytes := len(f.PayloadData) + maxHeaderBytes
-	if cap(writeBuffer) >= maxBytes {
-		writeBuffer = writeBuffer[:maxBytes]
+	copy(p, d.payload[:d.read])
+	if d.pooled {
+		c.pool.PutBuffer(d.payload)
+	}
+	d.payload = p
+	d.owned = true
+	d.pooled = c.borrowing
+}
+
+// fill reads more bytes into the buffer when it is empty. An idle connection
+// waits for the next frame on the small spill buffer, and takes a read buffer
+// from the pool only when more bytes are needed.
+func (c *Conn) fill(idle bool) (err error) {
+	var b []byte
+	if c.readBuffer != nil {
+		b = c.readBuffer
+	} else if idle {
+		if c.spill == nil {
+			c.spill = make([]byte, spillSize)
+		}
+		b = c.spill[:spillSize]
 	} else {
-		writeBuffer = make([]byte, maxBytes)
+		c.readBuffer = c.pool.GetBuffer(c.readBufferSize)
+		c.readBuffer = c.readBuffer[:cap(c.readBuffer)]
+		b = c.readBuffer
 	}
-	data, err := f.Marshal(writeBuffer)
-	if err == nil {
-		_, err = c.write(data)
-		if err != nil {
-			errMsg := err.Error()
-			if strings.Contains(errMsg, "use of closed network connection") || strings.Contains(errMsg, "connection reset by peer") {
-				err = io.EOF
+	n, err := c.read(b)
+	c.buffer = b[:n]
+	if n > 0 {
+		c.readBytes()
+	}
+	if err != nil {
+		c.release()
+	}
+	return err
+}
+
+// release returns the read buffer to the pool, and keeps the remaining bytes
+// in the spill buffer if they are few.
+func (c *Conn) release() {
+	if c.readBuffer == nil || len(c.buffer) > spillSize {
+		return
+	}
+	if len(c.buffer) > 0 && c.spill == nil {
+		c.spill = make([]byte, spillSize)
+	}
+	c.buffer = c.spill[:copy(c.spill[:spillSize], c.buffer)]
+	c.pool.PutBuffer(c.readBuffer)
+	c.readBuffer = nil
+}
+
+func (c *Conn) readError(err error) error {
+	errMsg := err.Error()
+	if strings.Contains(errMsg, "use of closed network connection") || strings.Contains(errMsg, "connection reset by peer") {
+		err = io.EOF
+	}
+	if err == io.EOF {
+		if atomic.LoadInt32(&c.closed) == 0 && c.failure() == nil {
+			if l := c.log(); l != nil {
+				l.Info("abnormal close", "code", CloseAbnormalClosure, "remote", remoteAddr(c.conn))
 			}
 		}
+		c.Close()
 	}
-	c.putFrame(f)
-	if c.shared {
-		c.writePool.PutBuffer(writeBuffer)
+	if failure := c.failure(); failure != nil {
+		return failure
+	}
+	return err
+}
+
+func (c *Conn) writeFrame(f *frame) (err error) {
+	if c.isClient {
+		f.Mask = 1
+		f.MaskingKey = maskingKey()
+	}
+	start := time.Now()
+	var n int64
+	if f.Mask == 0 && len(f.PayloadData) >= writevThreshold && c.writer == io.Writer(c.conn) {
+		n, err = c.writev(f)
 	} else {
-		c.writeBuffer = writeBuffer
+		writeBuffer := c.pool.GetBuffer(len(f.PayloadData) + maxHeaderBytes)
+		var data []byte
+		data, err = f.Marshal(writeBuffer)
+		if err == nil {
+			var written int
+			written, err = c.write(data)
+			n = int64(written)
+		}
+		c.pool.PutBuffer(writeBuffer)
+	}
+	// A buffered writer may have flushed a part of the frame.
+	c.cutOff = err != nil && (n > 0 || c.writer != io.Writer(c.conn))
+	if err == nil {
+		d := time.Since(start)
+		c.wroteFrameStats(f.Opcode, f.FIN, len(f.PayloadData), d)
+		c.traceFrameWritten(f.Opcode, f.FIN, len(f.PayloadData), d)
+		if f.Opcode >= CloseFrame {
+			err = c.flush()
+		} else {
+			c.wroteData()
+		}
+	}
+	if err != nil {
+		errMsg := err.Error()
+		if strings.Contains(errMsg, "use of closed network connection") || strings.Contains(errMsg, "connection reset by peer") {
+			err = io.EOF
+		}
 	}
+	c.putFrame(f)
 	return err
 }
 
+// writeFrames writes count messages as single frames in one write, and
+// returns the number of messages that were written completely.
+func (c *Conn) writeFrames(opcode byte, count int, message func(i int) []byte) (n int, err error) {
+	if c.writer != io.Writer(c.conn) {
+		for ; n < count; n++ {
+			if p := message(n); len(p) > 0 {
+				f := c.getFrame()
+				f.FIN = 1
+				f.Opcode = opcode
+				f.PayloadData = p
+				if err = c.writeFrame(f); err != nil {
+					return
+				}
+			}
+		}
+		return
+	}
+	var f frame
+	f.FIN = 1
+	f.Opcode = opcode
+	if c.isClient {
+		f.Mask = 1
+	}
+	var size int
+	var segments int
+	for i := 0; i < count; i++ {
+		f.PayloadData = message(i)
+		size += int(f.headerSize())
+		if f.Mask == 1 || len(f.PayloadData) < writevThreshold {
+			size += len(f.PayloadData)
+		} else {
+			segments += 2
+		}
+	}
+	writeBuffer := c.pool.GetBuffer(size)
+	data := writeBuffer[:0]
+	buffers := make(net.Buffers, 0, segments+1)
+	ends := make([]int64, count)
+	var end int64
+	for i := 0; i < count; i++ {
+		f.PayloadData = message(i)
+		if len(f.PayloadData) > 0 {
+			if f.Mask == 1 {
+				f.MaskingKey = maskingKey()
+			}
+			data = f.marshalHeader(data)
+			if f.Mask == 1 || len(f.PayloadData) < writevThreshold {
+				offset := len(data)
+				data = append(data, f.PayloadData...)
+				if f.Mask == 1 {
+					maskBytes(f.MaskingKey, data[offset:])
+				}
+			} else {
+				buffers = append(buffers, data, f.PayloadData)
+				data = data[len(data):]
+			}
+			end += int64(int(f.headerSize()) + len(f.PayloadData))
+		}
+		ends[i] = end
+	}
+	if len(data) > 0 {
+		buffers = append(buffers, data)
+	}
+	start := time.Now()
+	written, err := buffers.WriteTo(c.conn)
+	d := time.Since(start)
+	c.pool.PutBuffer(writeBuffer)
+	for n < count && ends[n] <= written {
+		if p := message(n); len(p) > 0 {
+			c.wroteFrameStats(opcode, 1, len(p), d)
+			c.traceFrameWritten(opcode, 1, len(p), d)
+			d = 0
+		}
+		n++
+	}
+	if written > 0 {
+		c.wroteData()
+	}
+	if err != nil {
+		var boundary int64
+		if n > 0 {
+			boundary = ends[n-1]
+		}
+		if written != boundary {
+			// A frame has been cut off, so the connection is unusable.
+			c.Close()
+		}
+		errMsg := err.Error()
+		if strings.Contains(errMsg, "use of closed network connection") || strings.Contains(errMsg, "connection reset by peer") {
+			err = io.EOF
+		}
+	}
+	return
+}
+
+type vector struct {
+	header  [maxHeaderBytes]byte
+	array   [2][]byte
+	buffers net.Buffers
+}
+
+var vectorPool = &sync.Pool{New: func() interface{} { return &vector{} }}
+
+// writev writes the header and the payload of an unmasked frame with
+// net.Buffers, so that the payload is neither copied nor buffered.
+func (c *Conn) writev(f *frame) (n int64, err error) {
+	v := vectorPool.Get().(*vector)
+	v.array[0] = f.marshalHeader(v.header[:0])
+	v.array[1] = f.PayloadData
+	v.buffers = v.array[:]
+	n, err = v.buffers.WriteTo(c.conn)
+	v.array[0], v.array[1] = nil, nil
+	v.buffers = nil
+	vectorPool.Put(v)
+	return
+}
+
 type frame struct {
 	FIN                   byte
 	RSV1                  byte
@@ -151,6 +478,7 @@ type frame struct {
 	ExtendedPayloadLength uint64
 	MaskingKey            []byte
 	PayloadData           []byte
+	pooled                bool
 }
 
 func (f *frame) Reset() {
@@ -158,6 +486,22 @@ func (f *frame) Reset() {
 }
 
 func (f *frame) Marshal(buf []byte) ([]byte, error) {
+	size := f.headerSize() + uint64(len(f.PayloadData))
+	if uint64(cap(buf)) >= size {
+		buf = buf[:size]
+	} else {
+		buf = make([]byte, size)
+	}
+	offset := uint64(len(f.marshalHeader(buf[:0])))
+	copy(buf[offset:], f.PayloadData)
+	if f.Mask == 1 {
+		maskBytes(f.MaskingKey, buf[offset:])
+	}
+	offset += uint64(len(f.PayloadData))
+	return buf[:offset], nil
+}
+
+func (f *frame) headerSize() uint64 {
 	var size uint64 = 2
 	if f.Mask == 1 {
 		size += 4
@@ -169,139 +513,73 @@ func (f *frame) Marshal(buf []byte) ([]byte, error) {
 	} else {
 		size += 8
 	}
-	size += uint64(len(f.PayloadData))
+	return size
+}
+
+// marshalHeader appends the header of the frame to buf.
+func (f *frame) marshalHeader(buf []byte) []byte {
+	offset := uint64(len(buf))
+	size := offset + f.headerSize()
 	if uint64(cap(buf)) >= size {
 		buf = buf[:size]
 	} else {
-		buf = make([]byte, size)
+		buf = append(buf, make([]byte, size-offset)...)
 	}
-	var offset uint64
-	buf[0] = f.FIN<<7 + f.RSV1<<6 + f.RSV2<<5 + f.RSV3<<4 + f.Opcode
+	length := uint64(len(f.PayloadData))
+	buf[offset] = f.FIN<<7 + f.RSV1<<6 + f.RSV2<<5 + f.RSV3<<4 + f.Opcode
 	if f.Mask == 0 {
-		buf[1] = 0x00
+		buf[offset+1] = 0x00
 	} else {
-		buf[1] = 0x80
+		buf[offset+1] = 0x80
 	}
 	if length <= 125 {
-		buf[1] |= byte(length)
+		buf[offset+1] |= byte(length)
 		offset += 2
 	} else if length < 65536 {
-		buf[1] |= 126
-		buf[2] = byte(length >> 8)
-		buf[3] = byte(length)
+		buf[offset+1] |= 126
+		buf[offset+2] = byte(length >> 8)
+		buf[offset+3] = byte(length)
 		offset += 4
 	} else {
-		buf[1] |= 127
-		buf[2] = byte(length >> 56)
-		buf[3] = byte(length >> 48)
-		buf[4] = byte(length >> 40)
-		buf[5] = byte(length >> 32)
-		buf[6] = byte(length >> 24)
-		buf[7] = byte(length >> 16)
-		buf[8] = byte(length >> 8)
-		buf[9] = byte(length)
+		buf[offset+1] |= 127
+		for i := uint64(0); i < 8; i++ {
+			buf[offset+2+i] = byte(length >> (56 - 8*i))
+		}
 		offset += 10
 	}
-	if f.Mask == 0 {
-		copy(buf[offset:], f.PayloadData)
-		offset += uint64(len(f.PayloadData))
-		return buf[:offset], nil
-	}
-	copy(buf[offset:offset+4], f.MaskingKey)
-	offset += 4
-	for i := 0; i < len(f.PayloadData); i++ {
-		f.PayloadData[i] = f.PayloadData[i] ^ f.MaskingKey[i%4]
+	if f.Mask == 1 {
+		copy(buf[offset:offset+4], f.MaskingKey)
 	}
-	copy(buf[offset:], f.PayloadData)
-	offset += uint64(len(f.PayloadData))
-	return buf[:offset], nil
+	return buf
 }
 
-func (f *frame) Unmarshal(data []byte) (uint64, error) {
-	var offset uint64
-	if uint64(len(data)) < offset+1 {
-		return 0, nil
-	}
-	f.FIN = data[0] >> 7
-	f.RSV1 = data[0] >> 6 & 1
-	f.RSV2 = data[0] >> 5 & 1
-	f.RSV3 = data[0] >> 4 & 1
-	f.Opcode = data[0] & 0xF
-	offset++
-	if uint64(len(data)) < offset+1 {
-		return 0, nil
-	}
-	f.Mask = data[1] >> 7
-	f.PayloadLength = byte(data[1] & 0x7F)
-	offset++
-	if f.PayloadLength <= 125 {
-	} else if f.PayloadLength == 126 {
-		if uint64(len(data)) < offset+2 {
-			return 0, nil
-		}
-		f.ExtendedPayloadLength |= uint64(data[2]) << 8
-		f.ExtendedPayloadLength |= uint64(data[3])
-		offset += 2
-	} else {
-		if uint64(len(data)) < offset+8 {
-			return 0, nil
-		}
-		f.ExtendedPayloadLength |= uint64(data[2]) << 56
-		f.ExtendedPayloadLength |= uint64(data[3]) << 48
-		f.ExtendedPayloadLength |= uint64(data[4]) << 40
-		f.ExtendedPayloadLength |= uint64(data[5]) << 32
-		f.ExtendedPayloadLength |= uint64(data[6]) << 24
-		f.ExtendedPayloadLength |= uint64(data[7]) << 16
-		f.ExtendedPayloadLength |= uint64(data[8]) << 8
-		f.ExtendedPayloadLength |= uint64(data[9])
-		offset += 8
+func maskBytes(key []byte, b []byte) {
+	k := uint64(binary.LittleEndian.Uint32(key))
+	k |= k << 32
+	for len(b) >= 8 {
+		binary.LittleEndian.PutUint64(b, binary.LittleEndian.Uint64(b)^k)
+		b = b[8:]
 	}
-	if f.Mask == 0 {
-		if f.ExtendedPayloadLength == 0 {
-			if uint64(len(data)) < offset+uint64(f.PayloadLength) {
-				return 0, nil
-			}
-			f.PayloadData = data[2 : 2+f.PayloadLength]
-			offset += uint64(f.PayloadLength)
-			return offset, nil
-		}
-		if uint64(len(data)) < offset+uint64(f.ExtendedPayloadLength) {
-			return 0, nil
-		}
-		f.PayloadData = data[offset : offset+f.ExtendedPayloadLength]
-		offset += uint64(f.ExtendedPayloadLength)
-		return offset, nil
+	for i := range b {
+		b[i] ^= key[i%4]
 	}
-	if f.ExtendedPayloadLength == 0 {
-		if uint64(len(data)) < offset+4+uint64(f.PayloadLength) {
-			return 0, nil
-		}
-		f.MaskingKey = data[2:6]
-		f.PayloadData = data[6 : 6+f.PayloadLength]
-		for i := 0; i < int(f.PayloadLength); i++ {
-			f.PayloadData[i] = f.PayloadData[i] ^ f.MaskingKey[i%4]
-		}
-		offset += 4 + uint64(f.PayloadLength)
-		return offset, nil
-	}
-
-	if uint64(len(data)) < offset+4+uint64(f.ExtendedPayloadLength) {
-		return 0, nil
-	}
-	f.MaskingKey = data[offset : offset+4]
-	offset += 4
-	f.PayloadData = data[offset : offset+f.ExtendedPayloadLength]
-	for i := 0; i < int(f.ExtendedPayloadLength); i++ {
-		f.PayloadData[i] = f.PayloadData[i] ^ f.MaskingKey[i%4]
-	}
-	offset += uint64(f.ExtendedPayloadLength)
-	return offset, nil
 }
 
-func maskingKey(random *rand.Rand) []byte {
+// randomPool holds the random number generators of the masking keys, so that
+// a client connection does not keep one of several kilobytes.
+var randomPool = &sync.Pool{New: func() interface{} {
+	seed := time.Now().UnixNano() + atomic.AddInt64(&randomSeeds, 1)
+	return rand.New(rand.NewSource(seed))
+}}
+
+var randomSeeds int64
+
+func maskingKey() []byte {
+	random := randomPool.Get().(*rand.Rand)
 	b := make([]byte, 4)
 	for i := 0; i < 4; i++ {
 		b[i] = byte(random.Intn(255))
 	}
+	randomPool.Put(random)
 	return b
 }
@@ -4,6 +4,9 @@
 package websocket
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"net"
 	"net/http"
 	"reflect"
@@ -12,131 +15,40 @@ import (
 )
 
 func TestFrame(t *testing.T) {
-	{
-		f := frame{FIN: 1, Opcode: BinaryFrame, PayloadData: make([]byte, 64)}
-		data, _ := f.Marshal(nil)
-		var f2 = frame{}
-		f2.Unmarshal(data)
-		if f.FIN != f2.FIN {
-			t.Error()
-		}
-		if f.Opcode != f2.Opcode {
-			t.Error()
-		}
-		if !reflect.DeepEqual(f.PayloadData, f2.PayloadData) {
-			t.Error()
-		}
-		for i := 0; i < len(data); i++ {
-			var f3 = frame{}
-			n, _ := f3.Unmarshal(data[:i])
-			if n != 0 {
-				t.Error(n, len(data))
-			}
-		}
+	decode := func(data []byte) (*frame, error) {
+		conn := server(&testSegmentConn{data: data, segment: len(data)}, "")
+		return conn.readFrame(nil)
 	}
-	{
-		buf := make([]byte, 64*1024)
-		f := frame{FIN: 1, Opcode: BinaryFrame, Mask: 1, MaskingKey: []byte{1, 2, 3, 4}, PayloadData: make([]byte, 64)}
-		cp := frame{FIN: 1, Opcode: BinaryFrame, Mask: 1, MaskingKey: []byte{1, 2, 3, 4}, PayloadData: make([]byte, 64)}
-		data, _ := cp.Marshal(buf)
-		var f2 = frame{}
-		f2.Unmarshal(data)
-		if f.FIN != f2.FIN {
-			t.Error()
-		}
-		if f.Opcode != f2.Opcode {
-			t.Error()
-		}
-		if f.Mask != f2.Mask {
-			t.Error()
-		}
-		if !reflect.DeepEqual(f.MaskingKey, f2.MaskingKey) {
-			t.Error()
-		}
-		if !reflect.DeepEqual(f.PayloadData, f2.PayloadData) {
-			t.Error()
-		}
-		for i := 0; i < len(data); i++ {
-			var f3 = frame{}
-			n, _ := f3.Unmarshal(data[:i])
-			if n != 0 {
-				t.Error(n, len(data))
+	for _, size := range []int{64, 512, 64 * 1024} {
+		for _, mask := range []byte{0, 1} {
+			f := frame{FIN: 1, Opcode: BinaryFrame, Mask: mask, PayloadData: make([]byte, size)}
+			for i := range f.PayloadData {
+				f.PayloadData[i] = byte(i)
 			}
-		}
-	}
-	{
-		buf := make([]byte, 64*1024)
-		f := frame{FIN: 1, Opcode: BinaryFrame, PayloadData: make([]byte, 512)}
-		data, _ := f.Marshal(buf)
-		var f2 = frame{}
-		f2.Unmarshal(data)
-		if f.FIN != f2.FIN {
-			t.Error()
-		}
-		if f.Opcode != f2.Opcode {
-			t.Error()
-		}
-		if !reflect.DeepEqual(f.PayloadData, f2.PayloadData) {
-			t.Error()
-		}
-		for i := 0; i < len(data); i++ {
-			var f3 = frame{}
-			n, _ := f3.Unmarshal(data[:i])
-			if n != 0 {
-				t.Error(n, len(data))
+			cp := f
+			cp.PayloadData = append([]byte{}, f.PayloadData...)
+			if mask == 1 {
+				cp.MaskingKey = []byte{1, 2, 3, 4}
 			}
-		}
-	}
-	{
-		buf := make([]byte, 128*1024)
-		f := frame{FIN: 1, Opcode: BinaryFrame, PayloadData: make([]byte, 64*1024)}
-		data, _ := f.Marshal(buf)
-		var f2 = frame{}
-		f2.Unmarshal(data)
-		if f.FIN != f2.FIN {
-			t.Error()
-		}
-		if f.Opcode != f2.Opcode {
-			t.Error()
-		}
-		if !reflect.DeepEqual(f.PayloadData, f2.PayloadData) {
-			t.Error()
-		}
-		for i := 0; i < len(data); i++ {
-			var f3 = frame{}
-			n, _ := f3.Unmarshal(data[:i])
-			if n != 0 {
-				t.Error(n, len(data))
+			data, _ := cp.Marshal(make([]byte, 128*1024))
+			f2, err := decode(data)
+			if err != nil {
+				t.Fatal(size, mask, err)
 			}
-		}
-	}
-	{
-		buf := make([]byte, 128*1024)
-		f := frame{FIN: 1, Opcode: BinaryFrame, Mask: 1, MaskingKey: []byte{1, 2, 3, 4}, PayloadData: make([]byte, 64*1024)}
-		cp := frame{FIN: 1, Opcode: BinaryFrame, Mask: 1, MaskingKey: []byte{1, 2, 3, 4}, PayloadData: make([]byte, 64*1024)}
-		data, _ := cp.Marshal(buf)
-		var f2 = frame{}
-		f2.Unmarshal(data)
-		if f.FIN != f2.FIN {
-			t.Error()
-		}
-		if f.Opcode != f2.Opcode {
-			t.Error()
-		}
-		if f.Mask != f2.Mask {
-			t.Error()
-		}
-		if !reflect.DeepEqual(f.MaskingKey, f2.MaskingKey) {
-			t.Error()
-		}
-		if !reflect.DeepEqual(f.PayloadData, f2.PayloadData) {
-			t.Error()
-		}
-		for i := 0; i < len(data); i++ {
-			var f3 = frame{}
-			n, _ := f3.Unmarshal(data[:i])
-			if n != 0 {
-				t.Error(n, len(data))
+			if f.FIN != f2.FIN || f.Opcode != f2.Opcode || f.Mask != f2.Mask {
+				t.Error(size, mask)
+			}
+			if !reflect.DeepEqual(f.PayloadData, f2.PayloadData) {
+				t.Error(size, mask)
+			}
+			// A truncated frame is not decoded, wherever it has been cut.
+			for i := 0; i < len(data); i++ {
+				if i > 16 && i+97 < len(data)-16 {
+					i += 97
+				}
+				if _, err := decode(data[:i]); err != io.EOF {
+					t.Error(size, mask, i, err)
+				}
 			}
 		}
 	}
@@ -226,22 +138,282 @@ func BenchmarkFrameMarshal(b *testing.B) {
 	}
 }
 
-func BenchmarkFrameUnmarshal(b *testing.B) {
+func BenchmarkFrameDecode(b *testing.B) {
 	buf := make([]byte, 64*1024)
 	f := &frame{FIN: 1, Opcode: BinaryFrame, PayloadData: make([]byte, 512)}
 	data, _ := f.Marshal(buf)
+	conn := server(&testSegmentConn{segment: len(data)}, "")
 	for i := 0; i < b.N; i++ {
-		var f2 = &frame{}
-		f2.Unmarshal(data)
+		conn.conn.(*testSegmentConn).data = data
+		f2, _ := conn.readFrame(buf)
+		conn.putFrame(f2)
 	}
 }
 
 func BenchmarkFrame(b *testing.B) {
 	buf := make([]byte, 64*1024)
 	f := &frame{FIN: 1, Opcode: BinaryFrame, PayloadData: make([]byte, 512)}
+	conn := server(&testSegmentConn{segment: 64 * 1024}, "")
 	for i := 0; i < b.N; i++ {
 		data, _ := f.Marshal(buf)
-		var f2 = &frame{}
-		f2.Unmarshal(data)
+		conn.conn.(*testSegmentConn).data = data
+		f2, _ := conn.readFrame(make([]byte, 512))
+		conn.putFrame(f2)
+	}
+}
+
+type testSegmentConn struct {
+	net.Conn
+	data    []byte
+	segment int
+	errs    map[int]error
+	reads   int
+	written bytes.Buffer
+}
+
+func (c *testSegmentConn) Read(b []byte) (n int, err error) {
+	c.reads++
+	if err, ok := c.errs[c.reads]; ok {
+		return 0, err
+	}
+	if len(c.data) == 0 {
+		return 0, io.EOF
+	}
+	n = len(c.data)
+	if n > c.segment {
+		n = c.segment
+	}
+	n = copy(b, c.data[:n])
+	c.data = c.data[n:]
+	return n, nil
+}
+
+func (c *testSegmentConn) Write(b []byte) (n int, err error) {
+	return c.written.Write(b)
+}
+
+func (c *testSegmentConn) Close() error {
+	return nil
+}
+
+func testFrames(sizes []int, mask bool) (data []byte, payloads [][]byte) {
+	for i, size := range sizes {
+		payload := make([]byte, size)
+		for j := range payload {
+			payload[j] = byte(i + j)
+		}
+		payloads = append(payloads, payload)
+		f := &frame{FIN: 1, Opcode: BinaryFrame, PayloadData: append([]byte{}, payload...)}
+		if mask {
+			f.Mask = 1
+			f.MaskingKey = []byte{1, 2, 3, 4}
+		}
+		b, _ := f.Marshal(nil)
+		data = append(data, b...)
+	}
+	return
+}
+
+func TestReadFrameSegments(t *testing.T) {
+	sizes := []int{0, 1, 125, 126, 1024, 65535, 65536, 200 * 1024}
+	for _, mask := range []bool{false, true} {
+		for _, segment := range []int{1, 7, 1460, 64 * 1024, 1 << 20} {
+			data, payloads := testFrames(sizes, mask)
+			conn := server(&testSegmentConn{data: data, segment: segment}, "")
+			for i, payload := range payloads {
+				buf := make([]byte, 512)
+				p, err := conn.ReadMessage(buf)
+				if err != nil {
+					t.Fatal(mask, segment, i, err)
+				} else if !bytes.Equal(p, payload) {
+					t.Fatal(mask, segment, i, len(p), len(payload))
+				}
+			}
+			if conn.readBuffer != nil {
+				t.Error(mask, segment)
+			}
+		}
+	}
+}
+
+func TestReadFrameResume(t *testing.T) {
+	sizes := []int{100, 1000, 100 * 1024}
+	data, payloads := testFrames(sizes, true)
+	errTimeout := errors.New("timeout")
+	errs := make(map[int]error)
+	for i := 2; i < 200; i += 3 {
+		errs[i] = errTimeout
+	}
+	conn := server(&testSegmentConn{data: data, segment: 333, errs: errs}, "")
+	for i := 0; i < len(payloads); {
+		buf := make([]byte, 64*1024)
+		p, err := conn.ReadMessage(buf)
+		if err == errTimeout {
+			for j := range buf {
+				buf[j] = 0
+			}
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p, payloads[i]) {
+			t.Fatal(i, len(p))
+		}
+		i++
+	}
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	data := []byte{0x82, 127, 0x80, 0, 0, 0, 0, 0, 0, 1}
+	conn := server(&testSegmentConn{data: data, segment: 1}, "")
+	if _, err := conn.ReadMessage(nil); err != ErrFrameTooLarge {
+		t.Error(err)
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	closeFrame := []byte{0x88, 2 + byte(len(ErrMessageTooBig.Error())), CloseMessageTooBig >> 8, CloseMessageTooBig & 0xFF}
+	for _, data := range [][]byte{
+		{0x82, 127, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{0x82, 127, 0, 0, 0, 1, 0, 0, 0, 0},
+		{0x82, 127, 0, 0, 0, 0, 0x02, 0, 0, 1},
+	} {
+		segmentConn := &testSegmentConn{data: data, segment: 1}
+		conn := server(segmentConn, "")
+		conn.SetReadLimit(32 << 20)
+		if _, err := conn.ReadMessage(nil); err != ErrMessageTooBig {
+			t.Error(err)
+		}
+		if !bytes.HasPrefix(segmentConn.written.Bytes(), closeFrame) {
+			t.Error(segmentConn.written.Bytes())
+		}
+	}
+	// The size of the messages is not limited by default.
+	conn := server(&testSegmentConn{data: []byte{0x82, 127, 0, 0, 0, 0, 0x02, 0, 0, 1}, segment: 1}, "")
+	if _, err := conn.ReadMessage(nil); err != io.EOF {
+		t.Error(err)
+	}
+	data := []byte{0x82, 126, 0x01, 0x00}
+	conn = server(&testSegmentConn{data: append(data, make([]byte, 256)...), segment: 1}, "")
+	conn.SetReadLimit(255)
+	if _, err := conn.ReadMessage(nil); err != ErrMessageTooBig {
+		t.Error(err)
+	}
+	conn = server(&testSegmentConn{data: append(data, make([]byte, 256)...), segment: 1}, "")
+	conn.SetReadLimit(256)
+	if p, err := conn.ReadMessage(nil); err != nil || len(p) != 256 {
+		t.Error(len(p), err)
+	}
+	var fragments []byte
+	for _, f := range []*frame{
+		{Opcode: BinaryFrame, PayloadData: make([]byte, 200)},
+		{FIN: 1, Opcode: PingFrame, PayloadData: make([]byte, 100)},
+		{Opcode: ContinuationFrame, PayloadData: make([]byte, 50)},
+		{FIN: 1, Opcode: ContinuationFrame, PayloadData: make([]byte, 50)},
+	} {
+		b, _ := f.Marshal(nil)
+		fragments = append(fragments, b...)
+	}
+	conn = server(&testSegmentConn{data: fragments, segment: 1}, "")
+	conn.SetReadLimit(256)
+	if _, err := conn.ReadMessage(nil); err != ErrMessageTooBig {
+		t.Error(err)
+	}
+	conn = server(&testSegmentConn{data: fragments, segment: 1}, "")
+	conn.SetReadLimit(300)
+	if p, err := conn.ReadMessage(nil); err != nil || len(p) != 300 {
+		t.Error(len(p), err)
+	}
+	// The payload grows as the bytes arrive, whatever the declared length.
+	data = []byte{0x82, 127, 0, 0, 0, 0, 0x40, 0, 0, 0}
+	conn = server(&testSegmentConn{data: append(data, make([]byte, 1024)...), segment: 1460}, "")
+	conn.SetReadLimit(1 << 30)
+	if _, err := conn.ReadMessage(nil); err != io.EOF {
+		t.Error(err)
+	}
+	if n := cap(conn.decoder.payload); n > bufferSize {
+		t.Error(n)
+	}
+}
+
+func benchmarkReadMessage(b *testing.B, size int) {
+	data, _ := testFrames([]int{size}, true)
+	conn := server(&testSegmentConn{segment: 1460}, "")
+	conn.SetReadLimit(size)
+	buf := make([]byte, size)
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn.conn.(*testSegmentConn).data = data
+		if _, err := conn.ReadMessage(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadMessage1KB(b *testing.B) {
+	benchmarkReadMessage(b, 1024)
+}
+
+func BenchmarkReadMessage64KB(b *testing.B) {
+	benchmarkReadMessage(b, 64*1024)
+}
+
+func BenchmarkReadMessage1MB(b *testing.B) {
+	benchmarkReadMessage(b, 1024*1024)
+}
+
+func BenchmarkReadMessage64MB(b *testing.B) {
+	benchmarkReadMessage(b, 64*1024*1024)
+}
+
+type testDiscardConn struct {
+	net.Conn
+	written int
+}
+
+func (c *testDiscardConn) Write(b []byte) (n int, err error) {
+	c.written += len(b)
+	return len(b), nil
+}
+
+func TestWriteFrameVectored(t *testing.T) {
+	discard := &testDiscardConn{}
+	conn := server(discard, "")
+	payload := make([]byte, 1024*1024)
+	if err := conn.WriteMessage(payload); err != nil {
+		t.Error(err)
+	}
+	if discard.written != len(payload)+10 {
+		t.Error(discard.written)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		conn.WriteMessage(payload)
+	})
+	if allocs > 0 {
+		t.Error(allocs)
+	}
+}
+
+func TestWriteFrameMasked(t *testing.T) {
+	data := &testDiscardConn{}
+	conn := client(data, "", "/")
+	payload := []byte("Hello World")
+	if err := conn.WriteMessage(payload); err != nil {
+		t.Error(err)
+	}
+	if string(payload) != "Hello World" {
+		t.Error(string(payload))
+	}
+}
+
+func BenchmarkWriteMessage1MB(b *testing.B) {
+	conn := server(&testDiscardConn{}, "")
+	payload := make([]byte, 1024*1024)
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		conn.WriteMessage(payload)
 	}
 }
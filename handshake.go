@@ -8,10 +8,10 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"errors"
-	"github.com/hslam/buffer"
 	"math/rand"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -20,81 +20,49 @@ const (
 	status = "101 Switching Protocols"
 )
 
-func server(conn net.Conn, shared bool, key string) *Conn {
-	var random = rand.New(rand.NewSource(time.Now().UnixNano()))
-	var readBufferSize = bufferSize
-	var writeBufferSize = bufferSize
-	readBufferSize += 14
-	writeBufferSize += 14
-	var readBuffer []byte
-	var writeBuffer []byte
-	var readPool *buffer.Pool
-	var writePool *buffer.Pool
-	if shared {
-		readPool = buffer.AssignPool(readBufferSize)
-		writePool = buffer.AssignPool(writeBufferSize)
-	} else {
-		readBuffer = make([]byte, readBufferSize)
-		writeBuffer = make([]byte, writeBufferSize)
-	}
+func server(conn net.Conn, key string) *Conn {
+	var readBufferSize = bufferSize + maxHeaderBytes
 	return &Conn{
-		conn:            conn,
-		writer:          conn,
-		random:          random,
-		shared:          shared,
-		readBufferSize:  readBufferSize,
-		writeBufferSize: writeBufferSize,
-		readBuffer:      readBuffer,
-		writeBuffer:     writeBuffer,
-		readPool:        readPool,
-		writePool:       writePool,
-		key:             key,
+		conn:           conn,
+		writer:         conn,
+		readBufferSize: readBufferSize,
+		pool:           DefaultBufferPool,
+		key:            key,
 	}
 }
 
-func client(conn net.Conn, shared bool, address, path string) *Conn {
-	var random = rand.New(rand.NewSource(time.Now().UnixNano()))
-	var readBufferSize = bufferSize
-	var writeBufferSize = bufferSize
-	readBufferSize += 14
-	writeBufferSize += 14
-	var readBuffer []byte
-	var writeBuffer []byte
-	var readPool *buffer.Pool
-	var writePool *buffer.Pool
-	if shared {
-		readPool = buffer.AssignPool(readBufferSize)
-		writePool = buffer.AssignPool(writeBufferSize)
-	} else {
-		readBuffer = make([]byte, readBufferSize)
-		writeBuffer = make([]byte, writeBufferSize)
-	}
+func client(conn net.Conn, address, path string) *Conn {
+	var readBufferSize = bufferSize + maxHeaderBytes
 	return &Conn{
-		isClient:        true,
-		conn:            conn,
-		writer:          conn,
-		random:          random,
-		shared:          shared,
-		readBufferSize:  readBufferSize,
-		writeBufferSize: writeBufferSize,
-		readBuffer:      readBuffer,
-		writeBuffer:     writeBuffer,
-		readPool:        readPool,
-		writePool:       writePool,
-		key:             key(random),
-		address:         address,
-		path:            path,
+		isClient:       true,
+		conn:           conn,
+		writer:         conn,
+		readBufferSize: readBufferSize,
+		pool:           DefaultBufferPool,
+		key:            key(),
+		address:        address,
+		path:           path,
 	}
 }
 
-func (c *Conn) handshake() error {
+func (c *Conn) handshake() (err error) {
 	if c.isClient {
-		return c.clientHandshake()
+		err = c.clientHandshake()
+	} else {
+		err = c.serverHandshake()
+	}
+	if err == nil {
+		c.openStats()
 	}
-	return c.serverHandshake()
+	return
 }
 
 func (c *Conn) clientHandshake() (err error) {
+	var subprotocols []string
+	if x := c.loadExtra(); x != nil {
+		subprotocols = x.subprotocols
+	}
+	trace := c.tracer()
 	c.accept = accept(c.key)
 	reqHeader := "GET " + c.path + " HTTP/1.1\r\n"
 	reqHeader += "Host: " + c.address + "\r\n"
@@ -102,16 +70,34 @@ func (c *Conn) clientHandshake() (err error) {
 	reqHeader += "Connection: Upgrade\r\n"
 	reqHeader += "Upgrade: websocket\r\n"
 	reqHeader += "Sec-WebSocket-Version: 13\r\n"
+	if len(subprotocols) > 0 {
+		reqHeader += "Sec-WebSocket-Protocol: " + strings.Join(subprotocols, ", ") + "\r\n"
+	}
 	reqHeader += "Sec-WebSocket-Key: " + c.key + "\r\n\r\n"
+	start := time.Now()
 	_, err = c.conn.Write([]byte(reqHeader))
+	if trace != nil && trace.WroteHandshake != nil {
+		trace.WroteHandshake(TraceInfo{Start: start, Duration: time.Since(start), Err: err})
+	}
 	if err == nil {
 		// Require successful HTTP response
 		// before switching to websocket protocol.
 		var resp *http.Response
-		resp, err = http.ReadResponse(bufio.NewReader(c.conn), &http.Request{Method: "GET"})
+		start = time.Now()
+		reader := bufio.NewReader(c.conn)
+		resp, err = http.ReadResponse(reader, &http.Request{Method: "GET"})
+		if trace != nil && trace.GotHandshakeResponse != nil {
+			trace.GotHandshakeResponse(resp, TraceInfo{Start: start, Duration: time.Since(start), Err: err})
+		}
 		if err == nil {
 			accept := resp.Header.Get("Sec-WebSocket-Accept")
 			if resp.Status == status && accept == c.accept {
+				c.subprotocol = resp.Header.Get("Sec-WebSocket-Protocol")
+				if c.subprotocol != "" && !contains(subprotocols, c.subprotocol) {
+					return errors.New("unexpected subprotocol: " + c.subprotocol)
+				}
+				p, _ := reader.Peek(reader.Buffered())
+				c.buffered(p)
 				return nil
 			}
 			err = errors.New("unexpected HTTP response: " + resp.Status)
@@ -125,16 +111,32 @@ func (c *Conn) serverHandshake() error {
 	respHeader := "HTTP/1.1 " + status + "\r\n"
 	respHeader += "Upgrade: websocket\r\n"
 	respHeader += "Connection: Upgrade\r\n"
-	respHeader += "Sec-WebSocket-Accept: " + c.accept + "\r\n\r\n"
+	if c.subprotocol != "" {
+		respHeader += "Sec-WebSocket-Protocol: " + c.subprotocol + "\r\n"
+	}
+	respHeader += "Sec-WebSocket-Accept: " + c.accept + "\r\n"
+	if x := c.loadExtra(); x != nil && len(x.responseHeader) > 0 {
+		var b strings.Builder
+		x.responseHeader.WriteSubset(&b, responseExclude)
+		respHeader += b.String()
+		x.responseHeader = nil
+	}
+	respHeader += "\r\n"
+	start := time.Now()
 	_, err := c.conn.Write([]byte(respHeader))
+	if trace := c.tracer(); trace != nil && trace.WroteHandshake != nil {
+		trace.WroteHandshake(TraceInfo{Start: start, Duration: time.Since(start), Err: err})
+	}
 	return err
 }
 
-func key(random *rand.Rand) string {
+func key() string {
+	random := randomPool.Get().(*rand.Rand)
 	b := make([]byte, 16)
 	for i := 0; i < 16; i++ {
 		b[i] = byte(random.Intn(255))
 	}
+	randomPool.Put(random)
 	return base64.StdEncoding.EncodeToString(b)
 }
 
@@ -144,3 +146,25 @@ func accept(key string) string {
 	h.Write([]byte(text))
 	return base64.StdEncoding.EncodeToString(h.Sum(nil))
 }
+
+// subprotocols returns the subprotocols requested by the client, in order of
+// preference.
+func subprotocols(r *http.Request) (protocols []string) {
+	for _, value := range r.Header["Sec-Websocket-Protocol"] {
+		for _, protocol := range strings.Split(value, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	return
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
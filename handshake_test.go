@@ -6,9 +6,13 @@ package websocket
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestWSS(t *testing.T) {
@@ -162,3 +166,62 @@ gxkf2m5HqKxP33Gqe1Q/nOjJasNNnQ9n1qHNkvyKReBpKpdKXEmzxGoowOEBg92h
 z45QURVAGlTYfOE=
 -----END CERTIFICATE-----
 `)
+
+func TestUpgradeLimits(t *testing.T) {
+	l, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := Upgrader{HandshakeTimeout: time.Millisecond * 100, MaxHeaderBytes: 1024}
+	errs := make(chan error, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, err = u.Upgrade(conn)
+			errs <- err
+		}
+	}()
+	test := func(request string) (resp string, err error) {
+		conn, err := net.Dial("tcp", ":8080")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.Write([]byte(request))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		b, readErr := ioutil.ReadAll(conn)
+		if readErr != nil {
+			t.Error(readErr)
+		}
+		return string(b), <-errs
+	}
+	start := time.Now()
+	if _, err := test(""); err == nil {
+		t.Error()
+	} else if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		t.Error(err)
+	}
+	if d := time.Since(start); d > time.Millisecond*500 {
+		t.Error(d)
+	}
+	if _, err := test("hello\r\n\r\n"); err == nil {
+		t.Error()
+	}
+	large := "GET / HTTP/1.1\r\nHost: :8080\r\nX-Large: "
+	large += strings.Repeat("a", u.MaxHeaderBytes+4096-len(large))
+	resp, err := test(large)
+	if err != ErrRequestTooLarge {
+		t.Error(err)
+	}
+	if !strings.HasPrefix(resp, "HTTP/1.1 431") {
+		t.Error(resp)
+	}
+	l.Close()
+	wg.Wait()
+}
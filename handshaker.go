@@ -0,0 +1,106 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ErrNeedMoreData is returned by the Feed method of a Handshaker until the
+// handshake request is complete.
+var ErrNeedMoreData = errors.New("need more data")
+
+// ErrRequestTooLarge is returned when the handshake request is too large.
+var ErrRequestTooLarge = errors.New("request too large")
+
+var headerEnd = []byte("\r\n\r\n")
+
+// Handshaker upgrades a connection to the WebSocket protocol with the bytes
+// that are fed to it, so that an event-driven server never blocks while the
+// handshake request is incomplete.
+//
+// The TLS handshake of crypto/tls cannot be driven this way. A Handshaker
+// ignores the TLSConfig of its Upgrader, so TLS should be terminated before
+// the bytes are fed to it, or the connection upgraded with Upgrade. The
+// HandshakeTimeout of the Upgrader only bounds the write of the response, so
+// the event loop should close the connections whose request is too slow.
+type Handshaker struct {
+	upgrader *Upgrader
+	conn     net.Conn
+	buffer   []byte
+	start    time.Time
+	err      error
+}
+
+// NewHandshaker returns a Handshaker that upgrades conn. The bytes read from
+// conn must be fed to the Handshaker instead of being read by it.
+func (u *Upgrader) NewHandshaker(conn net.Conn) *Handshaker {
+	return &Handshaker{upgrader: u, conn: conn}
+}
+
+// Feed feeds the bytes that have been read from the connection. It returns
+// ErrNeedMoreData until the handshake request is complete, and then the
+// upgraded connection. The bytes following the request are buffered by the
+// connection, and returned by its next reads.
+//
+// Feed writes the handshake response to the connection, which is small
+// enough not to block. On any other error, the connection has been closed.
+func (h *Handshaker) Feed(p []byte) (*Conn, error) {
+	if h.err != nil {
+		return nil, h.err
+	}
+	if h.start.IsZero() {
+		h.start = time.Now()
+	}
+	offset := len(h.buffer) - len(headerEnd) + 1
+	if offset < 0 {
+		offset = 0
+	}
+	h.buffer = append(h.buffer, p...)
+	i := bytes.Index(h.buffer[offset:], headerEnd)
+	if i < 0 {
+		if len(h.buffer) > h.upgrader.maxHeaderBytes() {
+			return nil, h.fail(h.upgrader.reject(remoteAddr(h.conn), ErrRequestTooLarge))
+		}
+		return nil, ErrNeedMoreData
+	}
+	end := offset + i + len(headerEnd)
+	if end > h.upgrader.maxHeaderBytes() {
+		return nil, h.fail(h.upgrader.reject(remoteAddr(h.conn), ErrRequestTooLarge))
+	}
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(h.buffer[:end])))
+	if err != nil {
+		return nil, h.fail(h.upgrader.reject(remoteAddr(h.conn), err))
+	}
+	req.RemoteAddr = remoteAddr(h.conn)
+	res := &response{conn: h.conn}
+	conn, err := h.upgrader.upgrade(res, req, TraceInfo{Start: h.start, Duration: time.Since(h.start)})
+	if err != nil {
+		return nil, h.fail(err)
+	}
+	conn.buffered(h.buffer[end:])
+	h.buffer = nil
+	h.err = errors.New("handshake completed")
+	return conn, nil
+}
+
+func (h *Handshaker) fail(err error) error {
+	h.conn.Close()
+	h.buffer = nil
+	h.err = err
+	return err
+}
+
+// buffered makes the next reads return p, which has been read from the
+// connection before the upgrade.
+func (c *Conn) buffered(p []byte) {
+	if len(p) > 0 {
+		c.buffer = append(make([]byte, 0, len(p)), p...)
+	}
+}
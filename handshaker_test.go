@@ -0,0 +1,100 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestHandshaker(t *testing.T) {
+	l, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var u Upgrader
+		h := u.NewHandshaker(conn)
+		buf := make([]byte, 7)
+		var feeds int
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			feeds++
+			ws, err := h.Feed(buf[:n])
+			if err == ErrNeedMoreData {
+				continue
+			} else if err != nil {
+				t.Error(err)
+				return
+			}
+			if feeds < 2 {
+				t.Error(feeds)
+			}
+			if r := ws.Request(); r.RemoteAddr != conn.RemoteAddr().String() {
+				t.Error(r.RemoteAddr)
+			}
+			msg, err := ws.ReadTextMessage()
+			if err != nil {
+				t.Error(err)
+			} else if msg != "Hello" {
+				t.Error(msg)
+			}
+			ws.Close()
+			return
+		}
+	}()
+	conn, err := net.Dial("tcp", ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := client(conn, ":8080", "/")
+	f := &frame{FIN: 1, Opcode: TextFrame, Mask: 1, MaskingKey: maskingKey(), PayloadData: []byte("Hello")}
+	data, _ := f.Marshal(nil)
+	req := "GET / HTTP/1.1\r\nHost: :8080\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: " + c.key + "\r\n\r\n"
+	if _, err := conn.Write(append([]byte(req), data...)); err != nil {
+		t.Error(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != accept(c.key) {
+		t.Error(resp.Status)
+	}
+	wg.Wait()
+	conn.Close()
+	l.Close()
+}
+
+func TestHandshakerTooLarge(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	var u Upgrader
+	h := u.NewHandshaker(server)
+	if _, err := h.Feed([]byte("GET / HTTP/1.1\r\n")); err != ErrNeedMoreData {
+		t.Error(err)
+	}
+	if _, err := h.Feed(bytes.Repeat([]byte("a"), http.DefaultMaxHeaderBytes)); err != ErrRequestTooLarge {
+		t.Error(err)
+	}
+	if _, err := h.Feed([]byte("\r\n\r\n")); err != ErrRequestTooLarge {
+		t.Error(err)
+	}
+}
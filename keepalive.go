@@ -0,0 +1,219 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type timeoutError struct {
+	text string
+}
+
+func (e *timeoutError) Error() string   { return e.text }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return false }
+
+var (
+	// ErrKeepAliveTimeout is returned when neither a pong nor any other frame
+	// arrives within the keepalive timeout after a ping. The connection is
+	// closed abnormally, as with the status code 1006.
+	ErrKeepAliveTimeout error = &timeoutError{"keepalive timeout"}
+	// ErrIdleTimeout is returned when the connection has been closed because
+	// no message has been read or written within the idle timeout.
+	ErrIdleTimeout error = &timeoutError{"idle timeout"}
+)
+
+// keepalive pings the peer and detects idle connections. It is driven by a
+// runtime timer instead of a goroutine, so that it costs nothing while
+// waiting, in both the goroutine per connection mode and the netpoll mode.
+type keepalive struct {
+	lock         sync.Mutex
+	conn         *Conn
+	interval     time.Duration
+	timeout      time.Duration
+	idleTimeout  time.Duration
+	timer        *time.Timer
+	lastRead     int64
+	lastActivity int64
+	pingAt       int64
+	stopped      bool
+}
+
+// SetKeepAlive sets the interval of the pings, and the timeout to wait for a
+// pong or any other frame after a ping before the connection is closed with
+// ErrKeepAliveTimeout. A zero interval disables the pings, and a zero timeout
+// means the interval.
+//
+// The pongs are handled by the reads, so the connection must be read
+// concurrently.
+func (c *Conn) SetKeepAlive(interval, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = interval
+	}
+	k := c.keepaliveLocked()
+	k.lock.Lock()
+	k.interval = interval
+	k.timeout = timeout
+	k.schedule(time.Now().UnixNano())
+	k.lock.Unlock()
+}
+
+// SetIdleTimeout sets the timeout that closes the connection with
+// ErrIdleTimeout when no message has been read or written, not counting the
+// control frames. A zero timeout disables it.
+func (c *Conn) SetIdleTimeout(timeout time.Duration) {
+	k := c.keepaliveLocked()
+	k.lock.Lock()
+	k.idleTimeout = timeout
+	k.schedule(time.Now().UnixNano())
+	k.lock.Unlock()
+}
+
+func (c *Conn) keepaliveLocked() *keepalive {
+	x := c.getExtra()
+	x.lock.Lock()
+	k := c.getKeepalive()
+	if k == nil {
+		now := time.Now().UnixNano()
+		k = &keepalive{conn: c, lastRead: now, lastActivity: now}
+		x.keepalive.Store(k)
+	}
+	x.lock.Unlock()
+	return k
+}
+
+func (c *Conn) getKeepalive() *keepalive {
+	x := c.loadExtra()
+	if x == nil {
+		return nil
+	}
+	k, _ := x.keepalive.Load().(*keepalive)
+	return k
+}
+
+// readData records a data frame that has been read.
+func (c *Conn) readData() {
+	if k := c.getKeepalive(); k != nil {
+		now := time.Now().UnixNano()
+		atomic.StoreInt64(&k.lastRead, now)
+		atomic.StoreInt64(&k.lastActivity, now)
+	}
+}
+
+// readControl records a control frame that has been read.
+func (c *Conn) readControl() {
+	if k := c.getKeepalive(); k != nil {
+		atomic.StoreInt64(&k.lastRead, time.Now().UnixNano())
+	}
+}
+
+// readBytes records bytes that have been read, so that a large message that
+// arrives slowly keeps the connection alive.
+func (c *Conn) readBytes() {
+	if k := c.getKeepalive(); k != nil {
+		atomic.StoreInt64(&k.lastRead, time.Now().UnixNano())
+	}
+}
+
+// wroteData records a data frame that has been written.
+func (c *Conn) wroteData() {
+	if k := c.getKeepalive(); k != nil {
+		atomic.StoreInt64(&k.lastActivity, time.Now().UnixNano())
+	}
+}
+
+func (c *Conn) stopKeepalive() {
+	if k := c.getKeepalive(); k != nil {
+		k.lock.Lock()
+		k.stopped = true
+		if k.timer != nil {
+			k.timer.Stop()
+		}
+		k.lock.Unlock()
+	}
+}
+
+// schedule arms the timer for the nearest deadline.
+func (k *keepalive) schedule(now int64) {
+	if k.stopped {
+		return
+	}
+	var next int64
+	earlier := func(t int64) {
+		if next == 0 || t < next {
+			next = t
+		}
+	}
+	if k.idleTimeout > 0 {
+		earlier(atomic.LoadInt64(&k.lastActivity) + int64(k.idleTimeout))
+	}
+	if k.interval > 0 {
+		if k.pingAt != 0 {
+			earlier(k.pingAt + int64(k.timeout))
+		} else {
+			earlier(atomic.LoadInt64(&k.lastRead) + int64(k.interval))
+		}
+	}
+	if next == 0 {
+		if k.timer != nil {
+			k.timer.Stop()
+		}
+		return
+	}
+	d := time.Duration(next - now)
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+	if k.timer == nil {
+		k.timer = time.AfterFunc(d, k.tick)
+	} else {
+		k.timer.Reset(d)
+	}
+}
+
+func (k *keepalive) tick() {
+	k.lock.Lock()
+	if k.stopped {
+		k.lock.Unlock()
+		return
+	}
+	c := k.conn
+	now := time.Now().UnixNano()
+	if k.idleTimeout > 0 && now-atomic.LoadInt64(&k.lastActivity) >= int64(k.idleTimeout) {
+		k.lock.Unlock()
+		if l := c.log(); l != nil {
+			l.Info("idle timeout", "remote", remoteAddr(c.conn))
+		}
+		c.writeClose(CloseGoingAway, "idle timeout")
+		c.fail(ErrIdleTimeout)
+		return
+	}
+	var ping bool
+	if k.interval > 0 {
+		lastRead := atomic.LoadInt64(&k.lastRead)
+		if k.pingAt != 0 && lastRead >= k.pingAt {
+			k.pingAt = 0
+		}
+		if k.pingAt != 0 && now-k.pingAt >= int64(k.timeout) {
+			k.lock.Unlock()
+			if l := c.log(); l != nil {
+				l.Info("keepalive timeout", "remote", remoteAddr(c.conn))
+			}
+			c.fail(ErrKeepAliveTimeout)
+			return
+		}
+		if k.pingAt == 0 && now-lastRead >= int64(k.interval) {
+			k.pingAt = now
+			ping = true
+		}
+	}
+	k.schedule(now)
+	k.lock.Unlock()
+	if ping {
+		c.ping(false)
+	}
+}
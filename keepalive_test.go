@@ -0,0 +1,263 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testServe(t *testing.T, setup func(conn *Conn), errs chan<- error) (l net.Listener, wg *sync.WaitGroup) {
+	l, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg = &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				break
+			}
+			ws, err := Upgrade(conn, nil)
+			if err != nil {
+				continue
+			}
+			if setup != nil {
+				setup(ws)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					messageType, msg, err := ws.ReadMessageType(nil)
+					if err != nil {
+						errs <- err
+						break
+					}
+					ws.WriteMessageType(messageType, msg)
+				}
+				ws.Close()
+			}()
+		}
+	}()
+	return
+}
+
+func TestKeepAlive(t *testing.T) {
+	errs := make(chan error, 1)
+	l, wg := testServe(t, func(conn *Conn) {
+		conn.SetKeepAlive(time.Millisecond*20, time.Millisecond*50)
+	}, errs)
+	conn, err := Dial("tcp", ":8080", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		conn.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+		conn.ReadMessage(nil)
+		close(done)
+	}()
+	<-done
+	select {
+	case err := <-errs:
+		t.Error(err)
+	default:
+	}
+	conn.SetReadDeadline(time.Time{})
+	select {
+	case err := <-errs:
+		if err != ErrKeepAliveTimeout {
+			t.Error(err)
+		}
+		if e, ok := err.(net.Error); !ok || !e.Timeout() {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("keepalive timeout")
+	}
+	conn.Close()
+	l.Close()
+	wg.Wait()
+}
+
+func TestIdleTimeout(t *testing.T) {
+	errs := make(chan error, 1)
+	l, wg := testServe(t, func(conn *Conn) {
+		conn.SetIdleTimeout(time.Millisecond * 100)
+	}, errs)
+	conn, err := Dial("tcp", ":8080", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		time.Sleep(time.Millisecond * 50)
+		conn.WriteMessage([]byte("Hello World"))
+		if _, err := conn.ReadMessage(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	_, err = conn.ReadMessage(nil)
+	if closeErr, ok := err.(*CloseError); !ok || closeErr.Code != CloseGoingAway {
+		t.Error(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Error(d)
+	}
+	if err := <-errs; err != ErrIdleTimeout {
+		t.Error(err)
+	}
+	conn.Close()
+	l.Close()
+	wg.Wait()
+}
+
+func TestIdleTimeoutWriteMessages(t *testing.T) {
+	errs := make(chan error, 1)
+	l, wg := testServe(t, func(conn *Conn) {
+		conn.SetIdleTimeout(time.Millisecond * 100)
+		go func() {
+			for i := 0; i < 10; i++ {
+				time.Sleep(time.Millisecond * 30)
+				if _, err := conn.WriteMessages([][]byte{[]byte("Hello"), []byte("World")}); err != nil {
+					return
+				}
+			}
+		}()
+	}, errs)
+	conn, err := Dial("tcp", ":8080", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := conn.ReadMessage(nil); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	if err := <-errs; err != ErrIdleTimeout {
+		t.Error(err)
+	}
+	conn.Close()
+	l.Close()
+	wg.Wait()
+}
+
+func TestKeepAliveSlowMessage(t *testing.T) {
+	errs := make(chan error, 1)
+	l, wg := testServe(t, func(conn *Conn) {
+		conn.SetKeepAlive(time.Millisecond*20, time.Millisecond*50)
+	}, errs)
+	conn, err := Dial("tcp", ":8080", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &frame{FIN: 1, Opcode: BinaryFrame, Mask: 1, MaskingKey: []byte{1, 2, 3, 4}, PayloadData: make([]byte, 1000)}
+	data, _ := f.Marshal(nil)
+	for len(data) > 0 {
+		n := 100
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := conn.conn.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+		time.Sleep(time.Millisecond * 30)
+	}
+	for {
+		msg, err := conn.ReadMessage(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg) == 1000 {
+			break
+		}
+	}
+	conn.Close()
+	if err := <-errs; err == ErrKeepAliveTimeout {
+		t.Error(err)
+	}
+	l.Close()
+	wg.Wait()
+}
+
+func TestControlFrames(t *testing.T) {
+	errs := make(chan error, 1)
+	l, wg := testServe(t, nil, errs)
+	{
+		conn, err := Dial("tcp", ":8080", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.writeControl(PingFrame, []byte("ping"))
+		conn.WriteMessage([]byte("Hello World"))
+		if data, err := conn.ReadMessage(nil); err != nil {
+			t.Error(err)
+		} else if string(data) != "Hello World" {
+			t.Error(string(data))
+		}
+		conn.writeClose(CloseNormalClosure, "bye")
+		if err := <-errs; err.(*CloseError).Code != CloseNormalClosure || err.(*CloseError).Text != "bye" {
+			t.Error(err)
+		}
+		if _, err := conn.ReadMessage(nil); err.(*CloseError).Code != CloseNormalClosure {
+			t.Error(err)
+		}
+		conn.Close()
+	}
+	{
+		conn, err := Dial("tcp", ":8080", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.writing.Lock()
+		conn.writeFrame(&frame{FIN: 1, RSV1: 1, Opcode: BinaryFrame, PayloadData: []byte("Hello World")})
+		conn.writing.Unlock()
+		if err := <-errs; err != ErrProtocol {
+			t.Error(err)
+		}
+		if _, err := conn.ReadMessage(nil); err.(*CloseError).Code != CloseProtocolError {
+			t.Error(err)
+		}
+		conn.Close()
+	}
+	{
+		conn, err := Dial("tcp", ":8080", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.writing.Lock()
+		conn.writeFrame(&frame{FIN: 1, Opcode: ContinuationFrame, PayloadData: []byte("Hello World")})
+		conn.writing.Unlock()
+		if err := <-errs; err != ErrProtocol {
+			t.Error(err)
+		}
+		conn.Close()
+	}
+	l.Close()
+	wg.Wait()
+}
+
+func TestCloseCodes(t *testing.T) {
+	for code, valid := range map[int]bool{
+		0: false, 999: false, 1000: true, 1003: true, 1004: false, 1005: false, 1006: false,
+		1007: true, 1014: true, 1015: false, 2999: false, 3000: true, 4999: true, 5000: false,
+	} {
+		f := &frame{FIN: 1, Opcode: CloseFrame, PayloadData: []byte{byte(code >> 8), byte(code)}}
+		data, _ := f.Marshal(nil)
+		conn := server(&testSegmentConn{data: data, segment: 1460}, "")
+		_, err := conn.ReadMessage(nil)
+		if closeErr, ok := err.(*CloseError); valid && (!ok || closeErr.Code != code) {
+			t.Error(code, err)
+		} else if !valid && err != ErrProtocol {
+			t.Error(code, err)
+		}
+	}
+}
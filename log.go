@@ -0,0 +1,141 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+)
+
+// Logger is the interface of a leveled logger with alternating key/value
+// pairs. It is implemented by *slog.Logger.
+//
+// Rejected upgrades, abnormal closes and timeouts are logged at the Info
+// level, protocol violations at the Warn level, and failures of the server
+// at the Error level.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// Level represents a logging level. The values match the levels of log/slog.
+type Level int
+
+const (
+	// LevelDebug is the debug level.
+	LevelDebug Level = -4
+	// LevelInfo is the info level.
+	LevelInfo Level = 0
+	// LevelWarn is the warn level.
+	LevelWarn Level = 4
+	// LevelError is the error level.
+	LevelError Level = 8
+)
+
+// String returns the name of the level.
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+var defaultLogger atomic.Value
+
+// SetLogger sets the logger of the connections and upgraders that have no
+// logger of their own, such as the connections upgraded by a Handler.
+func SetLogger(l Logger) {
+	defaultLogger.Store(&l)
+}
+
+func getLogger() Logger {
+	if l, ok := defaultLogger.Load().(*Logger); ok {
+		return *l
+	}
+	return nil
+}
+
+// NewLogger returns a Logger that writes the records of the provided
+// level and above to the standard logger l.
+func NewLogger(l *log.Logger, level Level) Logger {
+	return &stdLogger{logger: l, level: level}
+}
+
+type stdLogger struct {
+	logger *log.Logger
+	level  Level
+}
+
+func (l *stdLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.log(LevelDebug, msg, keysAndValues)
+}
+
+func (l *stdLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.log(LevelInfo, msg, keysAndValues)
+}
+
+func (l *stdLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.log(LevelWarn, msg, keysAndValues)
+}
+
+func (l *stdLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.log(LevelError, msg, keysAndValues)
+}
+
+func (l *stdLogger) log(level Level, msg string, keysAndValues []interface{}) {
+	if level < l.level {
+		return
+	}
+	var b bytes.Buffer
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 < len(keysAndValues) {
+			fmt.Fprintf(&b, " %v=%v", keysAndValues[i], keysAndValues[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", keysAndValues[i])
+		}
+	}
+	l.logger.Output(3, b.String())
+}
+
+// SetLogger sets the logger of the connection. It should be called before
+// the connection is used.
+func (c *Conn) SetLogger(l Logger) {
+	c.getExtra().logger = l
+}
+
+// log returns the logger of the connection, or nil.
+func (c *Conn) log() Logger {
+	if x := c.loadExtra(); x != nil && x.logger != nil {
+		return x.logger
+	}
+	return getLogger()
+}
+
+// log returns the logger of the upgrader, or nil.
+func (u *Upgrader) log() Logger {
+	if u.Logger != nil {
+		return u.Logger
+	}
+	return getLogger()
+}
+
+func remoteAddr(conn interface{ RemoteAddr() net.Addr }) string {
+	if addr := conn.RemoteAddr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bytes"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testLogger struct {
+	lock    sync.Mutex
+	records []string
+}
+
+func (l *testLogger) record(level, msg string, keysAndValues []interface{}) {
+	l.lock.Lock()
+	l.records = append(l.records, level+" "+msg)
+	l.lock.Unlock()
+}
+
+func (l *testLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.record("DEBUG", msg, keysAndValues)
+}
+
+func (l *testLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.record("INFO", msg, keysAndValues)
+}
+
+func (l *testLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.record("WARN", msg, keysAndValues)
+}
+
+func (l *testLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.record("ERROR", msg, keysAndValues)
+}
+
+func (l *testLogger) has(record string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, r := range l.records {
+		if r == record {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLogger(t *testing.T) {
+	logger := &testLogger{}
+	u := Upgrader{Logger: logger}
+	w := httptest.NewRecorder()
+	if _, err := u.UpgradeHTTP(w, httptest.NewRequest("GET", "/", nil)); err == nil {
+		t.Error()
+	}
+	if !logger.has("INFO upgrade rejected") {
+		t.Error(logger.records)
+	}
+
+	errs := make(chan error, 1)
+	l, wg := testServe(t, func(conn *Conn) {
+		conn.SetLogger(logger)
+	}, errs)
+	conn, err := Dial("tcp", ":8080", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := conn.getFrame()
+	f.FIN = 1
+	f.Opcode = ContinuationFrame
+	f.PayloadData = []byte("Hello")
+	conn.writeFrame(f)
+	if err := <-errs; err != ErrProtocol {
+		t.Error(err)
+	}
+	conn.Close()
+	l.Close()
+	wg.Wait()
+	if !logger.has("WARN protocol error") {
+		t.Error(logger.records)
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	var b bytes.Buffer
+	logger := NewLogger(log.New(&b, "", 0), LevelWarn)
+	logger.Info("upgrade rejected", "reason", "400 bad Key")
+	logger.Warn("protocol error", "reason", "invalid close frame", "remote")
+	if s := b.String(); s != "WARN protocol error reason=invalid close frame remote\n" {
+		t.Error(s)
+	}
+	if strings.Contains(b.String(), "INFO") {
+		t.Error(b.String())
+	}
+}
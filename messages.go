@@ -5,28 +5,23 @@ package websocket
 
 import (
 	"errors"
-	"github.com/hslam/buffer"
 	"github.com/hslam/writer"
 	"unsafe"
 )
 
+// ErrMessageType is returned when the type of a message is not expected.
+var ErrMessageType = errors.New("message type mismatch")
+
 // SetBufferedOutput sets the buffered writer with the buffer size.
 func (c *Conn) SetBufferedOutput(writeBufferSize int) {
 	c.writing.Lock()
-	if w, ok := c.writer.(*writer.Writer); ok {
+	if w, ok := c.writer.(flushCloser); ok {
 		w.Close()
 	}
 	if writeBufferSize > 0 {
 		c.writer = writer.NewWriter(c.conn, writeBufferSize)
 	} else {
 		c.writer = c.conn
-		writeBufferSize = bufferSize
-	}
-	c.writeBufferSize = writeBufferSize
-	if c.shared {
-		c.writePool = buffer.AssignPool(writeBufferSize)
-	} else {
-		c.writeBuffer = make([]byte, writeBufferSize)
 	}
 	c.writing.Unlock()
 }
@@ -38,99 +33,127 @@ func (c *Conn) SetBufferedInput(readBufferSize int) {
 	}
 	c.reading.Lock()
 	c.readBufferSize = readBufferSize
-	if c.shared {
-		c.readPool = buffer.AssignPool(readBufferSize)
-	} else {
-		c.readBuffer = make([]byte, readBufferSize)
-	}
 	c.reading.Unlock()
 }
 
-// ReceiveMessage receives single frame from ws, unmarshaled and stores in v.
+// SetReadLimit sets the maximum size of the messages read from ws. A larger
+// message closes the connection with the message too big status code, and
+// ErrMessageTooBig is returned. If limit is zero or less, the size of the
+// messages is not limited.
+func (c *Conn) SetReadLimit(limit int) {
+	c.reading.Lock()
+	c.maxMessageSize = limit
+	c.reading.Unlock()
+}
+
+// readLimit returns the maximum size of the messages read.
+func (c *Conn) readLimit() int {
+	if c.maxMessageSize > 0 {
+		return c.maxMessageSize
+	}
+	return maxInt
+}
+
+// ReceiveMessage receives single message from ws, unmarshaled and stores in v.
+// A *string receives a text message, and a *[]byte receives a binary message,
+// otherwise the message is discarded and ErrMessageType is returned.
+//
+// Other values are unmarshaled with encoding.TextUnmarshaler or
+// encoding.BinaryUnmarshaler if implemented, or else with the codec of the
+// connection.
 func (c *Conn) ReceiveMessage(v interface{}) (err error) {
 	c.reading.Lock()
-	c.buffer = c.buffer[:0]
 	c.connBuffer = c.connBuffer[:0]
-	var f *frame
-	f, err = c.readFrame(nil)
+	var opcode byte
+	var p []byte
+	opcode, p, err = c.readMessage(nil)
 	if err == nil {
-		switch data := v.(type) {
-		case *string:
-			*data = *(*string)(unsafe.Pointer(&f.PayloadData))
-			c.putFrame(f)
-		case *[]byte:
-			*data = f.PayloadData
-			c.putFrame(f)
-		default:
-			err = errors.New("not supported")
-		}
+		err = c.decode(opcode, p, v)
 	}
 	c.reading.Unlock()
 	return
 }
 
-// SendMessage sends v marshaled as single frame to ws.
+// decode stores the message in v for ReceiveMessage.
+func (c *Conn) decode(opcode byte, p []byte, v interface{}) error {
+	switch data := v.(type) {
+	case *string:
+		if opcode != TextFrame {
+			return ErrMessageType
+		}
+		// p is new memory that is never reused, so it can back the string.
+		*data = *(*string)(unsafe.Pointer(&p))
+	case *[]byte:
+		if opcode != BinaryFrame {
+			return ErrMessageType
+		}
+		*data = p
+	default:
+		return c.unmarshal(opcode, p, v)
+	}
+	return nil
+}
+
+// SendMessage sends v marshaled as single message to ws.
+// A string is sent as a text message, and a []byte is sent as a binary message.
+//
+// Other values are marshaled with encoding.BinaryMarshaler or
+// encoding.TextMarshaler if implemented, or else with the codec of the
+// connection.
 func (c *Conn) SendMessage(v interface{}) (err error) {
+	opcode, p, err := c.encode(v)
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(opcode, p)
+}
+
+// encode returns the message of v for SendMessage.
+func (c *Conn) encode(v interface{}) (opcode byte, p []byte, err error) {
 	switch data := v.(type) {
 	case string:
-		if len(data) > 0 {
-			c.writing.Lock()
-			f := c.getFrame()
-			f.FIN = 1
-			f.Opcode = TextFrame
-			f.PayloadData = []byte(data)
-			err = c.writeFrame(f)
-			c.writing.Unlock()
-		}
-		return
+		return TextFrame, []byte(data), nil
 	case *string:
-		if len(*data) > 0 {
-			c.writing.Lock()
-			f := c.getFrame()
-			f.FIN = 1
-			f.Opcode = TextFrame
-			f.PayloadData = []byte(*data)
-			err = c.writeFrame(f)
-			c.writing.Unlock()
-		}
-		return
+		return TextFrame, []byte(*data), nil
 	case []byte:
-		if len(data) > 0 {
-			c.writing.Lock()
-			f := c.getFrame()
-			f.FIN = 1
-			f.Opcode = BinaryFrame
-			f.PayloadData = data
-			err = c.writeFrame(f)
-			c.writing.Unlock()
-		}
-		return
+		return BinaryFrame, data, nil
 	case *[]byte:
-		if len(*data) > 0 {
-			c.writing.Lock()
-			f := c.getFrame()
-			f.FIN = 1
-			f.Opcode = BinaryFrame
-			f.PayloadData = *data
-			err = c.writeFrame(f)
-			c.writing.Unlock()
-		}
-		return
+		return BinaryFrame, *data, nil
+	}
+	return c.marshal(v)
+}
+
+func (c *Conn) writeMessage(opcode byte, b []byte) (err error) {
+	if len(b) > 0 {
+		c.writing.Lock()
+		f := c.getFrame()
+		f.FIN = 1
+		f.Opcode = opcode
+		f.PayloadData = b
+		err = c.writeFrame(f)
+		c.writing.Unlock()
 	}
-	return errors.New("not supported")
+	return
 }
 
-// ReadMessage reads single message from ws.
+// ReadMessage reads single message from ws. The payload is read into buf if
+// it is large enough, or else into new memory owned by the caller.
 func (c *Conn) ReadMessage(buf []byte) (p []byte, err error) {
 	c.reading.Lock()
-	c.buffer = c.buffer[:0]
 	c.connBuffer = c.connBuffer[:0]
-	var f *frame
-	f, err = c.readFrame(buf)
-	if err == nil {
-		p = f.PayloadData
-		c.putFrame(f)
-	}
+	_, p, err = c.readMessage(buf)
+	c.reading.Unlock()
+	return
+}
+
+// ReadMessageType reads single message from ws, and returns the message type
+// that is either TextFrame or BinaryFrame.
+func (c *Conn) ReadMessageType(buf []byte) (messageType int, p []byte, err error) {
+	c.reading.Lock()
+	c.connBuffer = c.connBuffer[:0]
+	var opcode byte
+	opcode, p, err = c.readMessage(buf)
+	messageType = int(opcode)
 	c.reading.Unlock()
 	return
 }
@@ -149,16 +172,47 @@ func (c *Conn) WriteMessage(b []byte) (err error) {
 	return
 }
 
-// ReadTextMessage reads single text message from ws.
+// WriteMessageType writes single message of the message type to ws.
+// The message type must be either TextFrame or BinaryFrame.
+func (c *Conn) WriteMessageType(messageType int, b []byte) (err error) {
+	if messageType != TextFrame && messageType != BinaryFrame {
+		return ErrMessageType
+	}
+	if len(b) > 0 {
+		c.writing.Lock()
+		f := c.getFrame()
+		f.FIN = 1
+		f.Opcode = byte(messageType)
+		f.PayloadData = b
+		err = c.writeFrame(f)
+		c.writing.Unlock()
+	}
+	return
+}
+
+// WriteMessages writes the messages to ws in one write under one lock
+// acquisition. It returns the number of messages that were written
+// completely. If a message is cut off by an error, the connection is closed,
+// since the peer can no longer parse the stream.
+func (c *Conn) WriteMessages(msgs [][]byte) (n int, err error) {
+	c.writing.Lock()
+	n, err = c.writeFrames(BinaryFrame, len(msgs), func(i int) []byte {
+		return msgs[i]
+	})
+	c.writing.Unlock()
+	return
+}
+
+// ReadTextMessage reads single text message from ws. The string is backed by
+// new memory that is never reused by the connection.
 func (c *Conn) ReadTextMessage() (p string, err error) {
 	c.reading.Lock()
-	c.buffer = c.buffer[:0]
 	c.connBuffer = c.connBuffer[:0]
-	var f *frame
-	f, err = c.readFrame(nil)
+	var b []byte
+	_, b, err = c.readMessage(nil)
 	if err == nil {
-		p = *(*string)(unsafe.Pointer(&f.PayloadData))
-		c.putFrame(f)
+		// b is new memory that is never reused, so it can back the string.
+		p = *(*string)(unsafe.Pointer(&b))
 	}
 	c.reading.Unlock()
 	return
@@ -177,3 +231,158 @@ func (c *Conn) WriteTextMessage(b string) (err error) {
 	}
 	return
 }
+
+// WriteTextMessages writes the text messages to ws in one write under one
+// lock acquisition, with the same semantics as WriteMessages.
+func (c *Conn) WriteTextMessages(msgs []string) (n int, err error) {
+	c.writing.Lock()
+	n, err = c.writeFrames(TextFrame, len(msgs), func(i int) []byte {
+		return *(*[]byte)(unsafe.Pointer(&struct {
+			string
+			int
+		}{msgs[i], len(msgs[i])}))
+	})
+	c.writing.Unlock()
+	return
+}
+
+// Message is a message borrowed from the connection by Borrow.
+//
+// Data is only valid until Release is called. After Release the memory is
+// reused by other reads, so neither Data nor any string or slice that aliases
+// it may be used or retained; copy the bytes to keep them.
+type Message struct {
+	// Type is the message type, TextFrame or BinaryFrame.
+	Type int
+	// Data is the payload of the message.
+	Data   []byte
+	pool   BufferPool
+	buffer []byte
+}
+
+// Release returns the memory of the message to the buffer pool.
+// Release must be called once, and it is safe to call it from another
+// goroutine than the one that borrowed the message.
+func (m *Message) Release() {
+	if m.buffer != nil {
+		m.pool.PutBuffer(m.buffer)
+	}
+	*m = Message{}
+}
+
+// Borrow reads single message from ws without copying the payload to memory
+// owned by the caller. The payload is taken from the buffer pool of the
+// connection, and must be returned by calling Release on the message.
+//
+// ReadMessage, ReadTextMessage and ReceiveMessage always return memory owned by
+// the caller instead, which is never reused by the connection.
+func (c *Conn) Borrow() (m *Message, err error) {
+	c.reading.Lock()
+	c.connBuffer = c.connBuffer[:0]
+	var opcode byte
+	var p []byte
+	var pooled bool
+	opcode, p, pooled, err = c.nextMessage(nil, true)
+	c.borrowing = false
+	c.reading.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	m = &Message{Type: int(opcode), Data: p}
+	if pooled {
+		m.pool = c.pool
+		m.buffer = p
+	}
+	return m, nil
+}
+
+// readMessage reads the frames of a message, and returns the opcode of the
+// first frame and the reassembled payload that is owned by the caller.
+func (c *Conn) readMessage(buf []byte) (opcode byte, p []byte, err error) {
+	opcode, p, _, err = c.nextMessage(buf, false)
+	return
+}
+
+// nextMessage reads the next message that is within the rate limit. If borrow
+// is true, the payload of a message in single frame is taken from the buffer
+// pool, and pooled reports whether it should be put back.
+func (c *Conn) nextMessage(buf []byte, borrow bool) (opcode byte, p []byte, pooled bool, err error) {
+	for {
+		if err = c.delay(); err != nil {
+			return
+		}
+		opcode, p, pooled, err = c.reassemble(buf, borrow)
+		if err != nil {
+			return
+		}
+		if err = c.limit(len(p)); err == nil {
+			return
+		}
+		if pooled {
+			c.pool.PutBuffer(p)
+		}
+		if err != errDropped {
+			return 0, nil, false, err
+		}
+	}
+}
+
+// reassemble reads the frames of a message.
+func (c *Conn) reassemble(buf []byte, borrow bool) (opcode byte, p []byte, pooled bool, err error) {
+	c.borrowing = borrow
+	for {
+		var f *frame
+		if c.fragmented {
+			f, err = c.nextFrame(nil)
+		} else {
+			f, err = c.nextFrame(buf)
+		}
+		if err != nil {
+			return
+		}
+		if c.fragmented != (f.Opcode == ContinuationFrame) {
+			c.putPayload(f)
+			c.putFrame(f)
+			err = c.protocolError("unexpected continuation frame")
+			return
+		}
+		if !c.fragmented {
+			if f.FIN == 1 {
+				opcode, p, pooled = f.Opcode, f.PayloadData, f.pooled
+				c.putFrame(f)
+				c.readMessageStats()
+				return
+			}
+			x := c.getExtra()
+			c.fragmented = true
+			c.fragmentOpcode = f.Opcode
+			x.fragments = f.PayloadData
+			if buf != nil || f.pooled {
+				x.fragments = append(make([]byte, 0, len(f.PayloadData)), f.PayloadData...)
+			}
+			c.putPayload(f)
+			c.putFrame(f)
+			continue
+		}
+		x := c.getExtra()
+		x.fragments = append(x.fragments, f.PayloadData...)
+		c.putPayload(f)
+		if f.FIN == 1 {
+			opcode, p = c.fragmentOpcode, x.fragments
+			c.fragmented = false
+			c.fragmentOpcode = 0
+			x.fragments = nil
+			c.putFrame(f)
+			c.readMessageStats()
+			return
+		}
+		c.putFrame(f)
+	}
+}
+
+func (c *Conn) putPayload(f *frame) {
+	if f.pooled {
+		c.pool.PutBuffer(f.PayloadData)
+		f.pooled = false
+	}
+}
@@ -4,9 +4,12 @@
 package websocket
 
 import (
+	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -15,11 +18,11 @@ func TestMessage(t *testing.T) {
 	addr := ":8080"
 	Serve := func(conn *Conn) {
 		for {
-			msg, err := conn.ReadMessage(nil)
+			messageType, msg, err := conn.ReadMessageType(nil)
 			if err != nil {
 				break
 			}
-			conn.WriteMessage(msg)
+			conn.WriteMessageType(messageType, msg)
 		}
 		conn.Close()
 	}
@@ -154,3 +157,294 @@ func TestMessage(t *testing.T) {
 	httpServer.Close()
 	wg.Wait()
 }
+
+func TestWriteMessages(t *testing.T) {
+	network := "tcp"
+	addr := ":8080"
+	Serve := func(conn *Conn) {
+		for {
+			msg, err := conn.ReadMessage(nil)
+			if err != nil {
+				break
+			}
+			conn.WriteMessage(msg)
+		}
+		conn.Close()
+	}
+
+	httpServer := &http.Server{
+		Addr:    addr,
+		Handler: Handler(Serve),
+	}
+	l, _ := net.Listen(network, addr)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		httpServer.Serve(l)
+	}()
+	conn, err := Dial(network, addr, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := [][]byte{[]byte("Hello"), nil, make([]byte, 64*1024), []byte("World")}
+	if n, err := conn.WriteMessages(msgs); err != nil {
+		t.Error(err)
+	} else if n != len(msgs) {
+		t.Error(n)
+	}
+	for _, msg := range msgs {
+		if len(msg) == 0 {
+			continue
+		}
+		data, err := conn.ReadMessage(nil)
+		if err != nil {
+			t.Error(err)
+		} else if string(data) != string(msg) {
+			t.Error(len(data))
+		}
+	}
+	texts := []string{"Hello", "World"}
+	if n, err := conn.WriteTextMessages(texts); err != nil {
+		t.Error(err)
+	} else if n != len(texts) {
+		t.Error(n)
+	}
+	for _, text := range texts {
+		data, err := conn.ReadTextMessage()
+		if err != nil {
+			t.Error(err)
+		} else if data != text {
+			t.Error(data)
+		}
+	}
+	conn.SetBufferedOutput(bufferSize)
+	if n, err := conn.WriteTextMessages(texts); err != nil {
+		t.Error(err)
+	} else if n != len(texts) {
+		t.Error(n)
+	}
+	for _, text := range texts {
+		data, err := conn.ReadTextMessage()
+		if err != nil {
+			t.Error(err)
+		} else if data != text {
+			t.Error(data)
+		}
+	}
+	conn.Close()
+	httpServer.Close()
+	wg.Wait()
+}
+
+type testLimitConn struct {
+	net.Conn
+	limit  int
+	closed bool
+}
+
+func (c *testLimitConn) Write(b []byte) (n int, err error) {
+	n = len(b)
+	if n > c.limit {
+		n = c.limit
+		err = io.ErrShortWrite
+	}
+	c.limit -= n
+	return
+}
+
+func (c *testLimitConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestWriteMessagesPartial(t *testing.T) {
+	msgs := [][]byte{make([]byte, 100), make([]byte, 10*1024), make([]byte, 100)}
+	{
+		limit := &testLimitConn{limit: 102 + 10*1024 + 4}
+		conn := server(limit, "")
+		if n, err := conn.WriteMessages(msgs); err == nil {
+			t.Error()
+		} else if n != 2 {
+			t.Error(n)
+		}
+		if limit.closed {
+			t.Error()
+		}
+	}
+	{
+		limit := &testLimitConn{limit: 102 + 10}
+		conn := server(limit, "")
+		if n, err := conn.WriteMessages(msgs); err == nil {
+			t.Error()
+		} else if n != 1 {
+			t.Error(n)
+		}
+		if !limit.closed {
+			t.Error()
+		}
+	}
+}
+
+func TestMessageType(t *testing.T) {
+	network := "tcp"
+	addr := ":8080"
+	Serve := func(conn *Conn) {
+		for {
+			messageType, msg, err := conn.ReadMessageType(nil)
+			if err != nil {
+				break
+			}
+			conn.WriteMessageType(messageType, msg)
+		}
+		conn.Close()
+	}
+
+	httpServer := &http.Server{
+		Addr:    addr,
+		Handler: Handler(Serve),
+	}
+	l, _ := net.Listen(network, addr)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		httpServer.Serve(l)
+	}()
+	conn, err := Dial(network, addr, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := "Hello World"
+	for _, messageType := range []int{TextFrame, BinaryFrame} {
+		if err := conn.WriteMessageType(messageType, []byte(msg)); err != nil {
+			t.Error(err)
+		}
+		if mt, data, err := conn.ReadMessageType(nil); err != nil {
+			t.Error(err)
+		} else if mt != messageType {
+			t.Error(mt)
+		} else if string(data) != msg {
+			t.Error(string(data))
+		}
+	}
+	if err := conn.WriteMessageType(PingFrame, []byte(msg)); err != ErrMessageType {
+		t.Error(err)
+	}
+	{
+		conn.SendMessage([]byte(msg))
+		var v string
+		if err := conn.ReceiveMessage(&v); err != ErrMessageType {
+			t.Error(err)
+		}
+		conn.SendMessage(msg)
+		var b []byte
+		if err := conn.ReceiveMessage(&b); err != ErrMessageType {
+			t.Error(err)
+		}
+	}
+	{
+		// A fragmented message is reassembled with the type of its first frame.
+		conn.writing.Lock()
+		conn.writeFrame(&frame{FIN: 0, Opcode: TextFrame, PayloadData: []byte("Hello ")})
+		conn.writeFrame(&frame{FIN: 0, Opcode: ContinuationFrame, PayloadData: []byte("Wor")})
+		conn.writeFrame(&frame{FIN: 1, Opcode: ContinuationFrame, PayloadData: []byte("ld")})
+		conn.writing.Unlock()
+		var v string
+		if err := conn.ReceiveMessage(&v); err != nil {
+			t.Error(err)
+		} else if v != msg {
+			t.Error(v)
+		}
+	}
+	conn.Close()
+	httpServer.Close()
+	wg.Wait()
+}
+
+func TestBorrow(t *testing.T) {
+	network := "tcp"
+	addr := ":8080"
+	Serve := func(conn *Conn) {
+		for {
+			messageType, msg, err := conn.ReadMessageType(nil)
+			if err != nil {
+				break
+			}
+			conn.WriteMessageType(messageType, msg)
+		}
+		conn.Close()
+	}
+
+	httpServer := &http.Server{
+		Addr:    addr,
+		Handler: Handler(Serve),
+	}
+	l, _ := net.Listen(network, addr)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		httpServer.Serve(l)
+	}()
+	pool := &testBufferPool{}
+	d := &Dialer{BufferPool: pool}
+	conn, err := d.Dial(network, addr, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var held []string
+	for i := 0; i < 16; i++ {
+		msg := fmt.Sprintf("Hello World %d", i)
+		conn.SendMessage(msg)
+		var v string
+		if i%2 == 0 {
+			err = conn.ReceiveMessage(&v)
+		} else {
+			v, err = conn.ReadTextMessage()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, v)
+	}
+	messages := make(chan *Message, 64)
+	var released sync.WaitGroup
+	released.Add(1)
+	go func() {
+		defer released.Done()
+		for m := range messages {
+			for i := range m.Data {
+				m.Data[i] = 0
+			}
+			m.Release()
+		}
+	}()
+	for i := 0; i < 256; i++ {
+		msg := fmt.Sprintf("Hello World %d", i)
+		conn.SendMessage(msg)
+		m, err := conn.Borrow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Type != TextFrame {
+			t.Error(m.Type)
+		} else if string(m.Data) != msg {
+			t.Error(string(m.Data))
+		}
+		messages <- m
+	}
+	close(messages)
+	released.Wait()
+	for i, v := range held {
+		if v != fmt.Sprintf("Hello World %d", i) {
+			t.Error(v)
+		}
+	}
+	conn.Close()
+	httpServer.Close()
+	wg.Wait()
+	if gets, puts := atomic.LoadInt64(&pool.gets), atomic.LoadInt64(&pool.puts); gets != puts {
+		t.Error(gets, puts)
+	}
+}
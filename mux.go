@@ -0,0 +1,197 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxDrainBytes is the maximum size of an unread request body that is
+// discarded to keep the connection alive.
+const maxDrainBytes = 256 << 10
+
+// ErrNotUpgraded is returned by the Upgrade method of a ServeMux when the
+// connection has only served plain HTTP requests, and has been closed.
+var ErrNotUpgraded = errors.New("not upgraded")
+
+// ServeMux routes the requests read from raw connections, such as the
+// connections of an event loop. The WebSocket upgrade requests for a
+// registered path are upgraded, and all the other requests are served by
+// the Fallback handler, so that one port serves both WebSocket and plain
+// HTTP endpoints such as health checks and metrics.
+type ServeMux struct {
+	// Upgrader upgrades the connections to the WebSocket protocol.
+	Upgrader Upgrader
+	// Fallback serves the requests that are not WebSocket upgrades for a
+	// registered path. If nil, they are replied with 404 not found.
+	Fallback http.Handler
+
+	lock     sync.RWMutex
+	handlers map[string]func(*Conn)
+}
+
+// NewServeMux returns a new ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{}
+}
+
+// Handle registers the handler for the path. A path ending with a slash
+// also matches the paths under it, and the longest match wins. The handler
+// is called by ServeConn, and may be nil if the connections are only
+// upgraded with Upgrade.
+func (m *ServeMux) Handle(path string, handler func(*Conn)) {
+	m.lock.Lock()
+	if m.handlers == nil {
+		m.handlers = make(map[string]func(*Conn))
+	}
+	m.handlers[path] = handler
+	m.lock.Unlock()
+}
+
+// match returns the handler registered for the path.
+func (m *ServeMux) match(path string) (handler func(*Conn), ok bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if handler, ok = m.handlers[path]; ok {
+		return
+	}
+	var longest string
+	for pattern, h := range m.handlers {
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) && len(pattern) > len(longest) {
+			longest, handler, ok = pattern, h, true
+		}
+	}
+	return
+}
+
+// Upgrade serves the plain HTTP requests read from conn with the Fallback
+// handler, until a WebSocket upgrade request for a registered path is read,
+// and returns the upgraded connection. It returns ErrNotUpgraded when the
+// connection has been closed after plain HTTP requests.
+func (m *ServeMux) Upgrade(conn net.Conn) (*Conn, error) {
+	ws, _, err := m.upgrade(conn)
+	return ws, err
+}
+
+// ServeConn upgrades conn like Upgrade, calls the handler registered for the
+// path of the upgrade request, and closes the connection when it returns.
+func (m *ServeMux) ServeConn(conn net.Conn) {
+	ws, handler, err := m.upgrade(conn)
+	if err != nil {
+		return
+	}
+	if handler != nil {
+		handler(ws)
+	}
+	ws.Close()
+}
+
+func (m *ServeMux) upgrade(conn net.Conn) (*Conn, func(*Conn), error) {
+	u := &m.Upgrader
+	conn, err := u.serverTLS(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := u.newRequestReader(conn)
+	for served := 0; ; served++ {
+		req, begin, err := r.read()
+		if err != nil {
+			if served > 0 && err == io.EOF {
+				return nil, nil, ErrNotUpgraded
+			}
+			return nil, nil, u.reject(remoteAddr(conn), err)
+		}
+		handler, ok := m.match(req.URL.Path)
+		if ok && strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+			ws, err := r.upgrade(req, begin)
+			return ws, handler, err
+		}
+		if !m.serveHTTP(conn, req) {
+			conn.Close()
+			return nil, nil, ErrNotUpgraded
+		}
+		if u.HandshakeTimeout > 0 {
+			conn.SetDeadline(time.Now().Add(u.HandshakeTimeout))
+		}
+	}
+}
+
+// serveHTTP serves the plain HTTP request with the Fallback handler, and
+// reports whether the connection can be kept alive.
+func (m *ServeMux) serveHTTP(conn net.Conn, req *http.Request) bool {
+	handler := m.Fallback
+	if handler == nil {
+		handler = http.NotFoundHandler()
+	}
+	w := &httpResponse{conn: conn, req: req, header: make(http.Header)}
+	handler.ServeHTTP(w, req)
+	keepAlive := !req.Close && req.ProtoAtLeast(1, 1)
+	if n, _ := io.Copy(ioutil.Discard, io.LimitReader(req.Body, maxDrainBytes+1)); n > maxDrainBytes {
+		keepAlive = false
+	}
+	req.Body.Close()
+	return w.finish(keepAlive) == nil && keepAlive
+}
+
+// httpResponse is a minimal http.ResponseWriter that buffers the body, so
+// that the response has a Content-Length and the connection can be kept
+// alive.
+type httpResponse struct {
+	conn   net.Conn
+	req    *http.Request
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *httpResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *httpResponse) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *httpResponse) Write(data []byte) (n int, err error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(data)
+}
+
+// finish writes the response.
+func (w *httpResponse) finish(keepAlive bool) error {
+	w.WriteHeader(http.StatusOK)
+	h := w.header
+	bodyAllowed := w.status >= 200 && w.status != http.StatusNoContent && w.status != http.StatusNotModified
+	if bodyAllowed {
+		if h.Get("Content-Type") == "" && w.body.Len() > 0 {
+			h.Set("Content-Type", http.DetectContentType(w.body.Bytes()))
+		}
+		h.Set("Content-Length", strconv.Itoa(w.body.Len()))
+	}
+	h.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if !keepAlive {
+		h.Set("Connection", "close")
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "HTTP/1.1 %03d %s\r\n", w.status, http.StatusText(w.status))
+	h.Write(&b)
+	b.WriteString("\r\n")
+	if bodyAllowed && w.req.Method != "HEAD" {
+		b.Write(w.body.Bytes())
+	}
+	_, err := w.conn.Write(b.Bytes())
+	return err
+}
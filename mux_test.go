@@ -0,0 +1,91 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestServeMux(t *testing.T) {
+	fallback := http.NewServeMux()
+	fallback.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	m := NewServeMux()
+	m.Fallback = fallback
+	m.Handle("/ws/", func(conn *Conn) {
+		for {
+			msg, err := conn.ReadMessage(nil)
+			if err != nil {
+				break
+			}
+			conn.WriteMessage(msg)
+		}
+	})
+	l, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m.ServeConn(conn)
+			}()
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	for _, path := range []string{"/healthz", "/healthz", "/missing"} {
+		conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: :8080\r\n\r\n"))
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if path == "/healthz" && (resp.StatusCode != http.StatusOK || string(body) != "ok") {
+			t.Error(resp.Status, string(body))
+		} else if path == "/missing" && resp.StatusCode != http.StatusNotFound {
+			t.Error(resp.Status)
+		}
+	}
+	conn.Close()
+
+	ws, err := Dial("tcp", ":8080", "/ws/echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteMessage([]byte("Hello")); err != nil {
+		t.Error(err)
+	}
+	if msg, err := ws.ReadMessage(nil); err != nil {
+		t.Error(err)
+	} else if string(msg) != "Hello" {
+		t.Error(string(msg))
+	}
+	ws.Close()
+
+	if _, err := Dial("tcp", ":8080", "/other", nil); err == nil {
+		t.Error()
+	}
+	l.Close()
+	wg.Wait()
+}
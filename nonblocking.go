@@ -0,0 +1,51 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"errors"
+	"sync/atomic"
+	"syscall"
+)
+
+// ErrWouldBlock is returned by the reads of a non-blocking connection when
+// no complete message is ready.
+var ErrWouldBlock = errors.New("would block")
+
+// SetNonBlocking sets whether the reads of the connection are non-blocking,
+// for the handlers of an event loop. It should be called before the
+// connection is read.
+//
+// In non-blocking mode, ReadMessage, ReceiveMessage and the other reads
+// consume only the bytes that are available, and return ErrWouldBlock when
+// no complete message is ready. The partial frame or message is kept by the
+// connection and completed by the next reads. As a read may take several
+// messages from the kernel at once, the handler of a readiness event must
+// read the messages until ErrWouldBlock is returned, or the buffered ones
+// wait for the peer to send more.
+//
+// The connections that expose their file descriptor through syscall.Conn,
+// such as TCP connections, are read without blocking on Unix systems. Other
+// connections, such as TLS connections, only avoid blocking if their Read
+// returns syscall.EAGAIN when no data is available.
+func (c *Conn) SetNonBlocking(nonBlocking bool) {
+	if nonBlocking {
+		c.getExtra().rawConn = rawConn(c.conn)
+		atomic.StoreInt32(&c.nonBlocking, 1)
+	} else {
+		atomic.StoreInt32(&c.nonBlocking, 0)
+	}
+}
+
+// readNonBlocking reads the bytes that are available.
+func (c *Conn) readNonBlocking(b []byte) (n int, err error) {
+	if x := c.loadExtra(); x != nil && x.rawConn != nil {
+		return rawRead(x.rawConn, b)
+	}
+	n, err = c.conn.Read(b)
+	if errors.Is(err, syscall.EAGAIN) {
+		err = ErrWouldBlock
+	}
+	return
+}
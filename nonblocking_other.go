@@ -0,0 +1,20 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package websocket
+
+import (
+	"net"
+	"syscall"
+)
+
+func rawConn(conn net.Conn) syscall.RawConn {
+	return nil
+}
+
+func rawRead(rc syscall.RawConn, b []byte) (n int, err error) {
+	return 0, ErrWouldBlock
+}
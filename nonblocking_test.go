@@ -0,0 +1,93 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNonBlocking(t *testing.T) {
+	l, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	half := make(chan struct{})
+	batch := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ws, err := Upgrade(conn, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer ws.Close()
+		ws.SetNonBlocking(true)
+		if _, err := ws.ReadMessage(nil); err != ErrWouldBlock {
+			t.Error(err)
+		}
+		close(half)
+		deadline := time.Now().Add(time.Second)
+		var polls int
+		for time.Now().Before(deadline) {
+			var msg string
+			err := ws.ReceiveMessage(&msg)
+			if err == ErrWouldBlock {
+				polls++
+				time.Sleep(time.Millisecond)
+				continue
+			} else if err != nil {
+				t.Error(err)
+			} else if msg != "Hello World" {
+				t.Error(msg)
+			}
+			break
+		}
+		if polls == 0 {
+			t.Error(polls)
+		}
+		if _, err := ws.ReadMessage(nil); err != ErrWouldBlock {
+			t.Error(err)
+		}
+		close(batch)
+		// The messages of one read are buffered, and read without waiting
+		// for the next readiness event.
+		for time.Now().Before(deadline) {
+			if _, err := ws.ReadMessage(nil); err != ErrWouldBlock {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if msg, err := ws.ReadMessage(nil); err != nil || string(msg) != "2" {
+			t.Error(string(msg), err)
+		}
+		if _, err := ws.ReadMessage(nil); err != ErrWouldBlock {
+			t.Error(err)
+		}
+	}()
+	conn, err := Dial("tcp", ":8080", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &frame{FIN: 1, Opcode: TextFrame, Mask: 1, MaskingKey: maskingKey(), PayloadData: []byte("Hello World")}
+	data, _ := f.Marshal(nil)
+	<-half
+	conn.conn.Write(data[:8])
+	time.Sleep(time.Millisecond * 50)
+	conn.conn.Write(data[8:])
+	<-batch
+	conn.WriteMessages([][]byte{[]byte("1"), []byte("2")})
+	wg.Wait()
+	conn.Close()
+	l.Close()
+}
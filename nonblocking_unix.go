@@ -0,0 +1,44 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package websocket
+
+import (
+	"io"
+	"net"
+	"syscall"
+)
+
+func rawConn(conn net.Conn) syscall.RawConn {
+	if sc, ok := conn.(syscall.Conn); ok {
+		if rc, err := sc.SyscallConn(); err == nil {
+			return rc
+		}
+	}
+	return nil
+}
+
+// rawRead reads once from the non-blocking file descriptor, without waiting
+// for it to be readable.
+func rawRead(rc syscall.RawConn, b []byte) (n int, err error) {
+	var readErr error
+	err = rc.Read(func(fd uintptr) bool {
+		n, readErr = syscall.Read(int(fd), b)
+		return true
+	})
+	if err == nil {
+		err = readErr
+	}
+	if n < 0 {
+		n = 0
+	}
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return 0, ErrWouldBlock
+	} else if err == nil && n == 0 && len(b) > 0 {
+		err = io.EOF
+	}
+	return
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+// RTT represents the round-trip time statistics of a connection, measured by
+// the pings of Ping and of the keepalive.
+type RTT struct {
+	// Last is the last round-trip time.
+	Last time.Duration
+	// Smoothed is the smoothed round-trip time as in RFC 6298.
+	Smoothed time.Duration
+	// Jitter is the round-trip time variation as in RFC 6298.
+	Jitter time.Duration
+	// Min is the minimum round-trip time.
+	Min time.Duration
+	// Max is the maximum round-trip time.
+	Max time.Duration
+	// Samples is the number of the measured round trips.
+	Samples uint64
+}
+
+func (r *RTT) add(rtt time.Duration) {
+	if r.Samples == 0 {
+		r.Smoothed = rtt
+		r.Jitter = rtt / 2
+		r.Min = rtt
+		r.Max = rtt
+	} else {
+		delta := r.Smoothed - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		r.Jitter = (3*r.Jitter + delta) / 4
+		r.Smoothed = (7*r.Smoothed + rtt) / 8
+		if rtt < r.Min {
+			r.Min = rtt
+		}
+		if rtt > r.Max {
+			r.Max = rtt
+		}
+	}
+	r.Last = rtt
+	r.Samples++
+}
+
+type pingResult struct {
+	rtt time.Duration
+	err error
+}
+
+type pendingPing struct {
+	sent   time.Time
+	result chan pingResult
+}
+
+// pinger matches the pongs with the pings by their unique payloads.
+type pinger struct {
+	lock    sync.Mutex
+	seq     uint64
+	pending map[uint64]pendingPing
+	rtt     RTT
+	err     error
+}
+
+func (c *Conn) getPinger() *pinger {
+	x := c.getExtra()
+	x.lock.Lock()
+	if x.pinger == nil {
+		x.pinger = &pinger{pending: make(map[uint64]pendingPing)}
+	}
+	p := x.pinger
+	x.lock.Unlock()
+	return p
+}
+
+// loadPinger returns the pinger of the connection, or nil if no ping has
+// been written.
+func (c *Conn) loadPinger() (p *pinger) {
+	if x := c.loadExtra(); x != nil {
+		x.lock.Lock()
+		p = x.pinger
+		x.lock.Unlock()
+	}
+	return
+}
+
+// ping writes a ping with a unique payload. If wait is true, the returned
+// channel receives the round-trip time when the matching pong arrives.
+func (c *Conn) ping(wait bool) (seq uint64, result chan pingResult, err error) {
+	p := c.getPinger()
+	if wait {
+		result = make(chan pingResult, 1)
+	}
+	var payload [8]byte
+	p.lock.Lock()
+	if p.err != nil {
+		err = p.err
+		p.lock.Unlock()
+		return
+	}
+	p.seq++
+	seq = p.seq
+	p.pending[seq] = pendingPing{sent: time.Now(), result: result}
+	p.lock.Unlock()
+	binary.BigEndian.PutUint64(payload[:], seq)
+	if err = c.writeControl(PingFrame, payload[:]); err != nil {
+		p.lock.Lock()
+		delete(p.pending, seq)
+		p.lock.Unlock()
+	}
+	return
+}
+
+// Ping writes a ping with a unique payload, and returns the round-trip time
+// when the matching pong arrives. The pongs are handled by the reads, so the
+// connection must be read concurrently.
+func (c *Conn) Ping(ctx context.Context) (rtt time.Duration, err error) {
+	seq, result, err := c.ping(true)
+	if err != nil {
+		return 0, err
+	}
+	select {
+	case r := <-result:
+		return r.rtt, r.err
+	case <-ctx.Done():
+		p := c.getPinger()
+		p.lock.Lock()
+		delete(p.pending, seq)
+		p.lock.Unlock()
+		return 0, ctx.Err()
+	}
+}
+
+// RTT returns the round-trip time statistics of the connection.
+func (c *Conn) RTT() (rtt RTT) {
+	p := c.loadPinger()
+	if p != nil {
+		p.lock.Lock()
+		rtt = p.rtt
+		p.lock.Unlock()
+	}
+	return
+}
+
+// pong handles a pong frame.
+func (c *Conn) pong(payload []byte) {
+	if len(payload) != 8 {
+		return
+	}
+	p := c.loadPinger()
+	if p == nil {
+		return
+	}
+	seq := binary.BigEndian.Uint64(payload)
+	now := time.Now()
+	p.lock.Lock()
+	if pending, ok := p.pending[seq]; ok {
+		rtt := now.Sub(pending.sent)
+		p.rtt.add(rtt)
+		if pending.result != nil {
+			pending.result <- pingResult{rtt: rtt}
+		}
+		for s, pending := range p.pending {
+			// The pongs arrive in order, so the earlier pings are lost.
+			if s <= seq && pending.result == nil {
+				delete(p.pending, s)
+			}
+		}
+		delete(p.pending, seq)
+	}
+	p.lock.Unlock()
+}
+
+// stopPinger fails the pending pings when the connection is closed.
+func (c *Conn) stopPinger() {
+	p := c.loadPinger()
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	p.err = io.EOF
+	for seq, pending := range p.pending {
+		if pending.result != nil {
+			pending.result <- pingResult{err: io.EOF}
+		}
+		delete(p.pending, seq)
+	}
+	p.lock.Unlock()
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPing(t *testing.T) {
+	errs := make(chan error, 1)
+	servers := make(chan *Conn, 1)
+	l, wg := testServe(t, func(conn *Conn) {
+		conn.SetKeepAlive(time.Millisecond*10, time.Second)
+		servers <- conn
+	}, errs)
+	conn, err := Dial("tcp", ":8080", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-servers
+	{
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		if _, err := conn.Ping(ctx); err != context.DeadlineExceeded {
+			t.Error(err)
+		}
+		cancel()
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			if _, err := conn.ReadMessage(nil); err != nil {
+				break
+			}
+		}
+		close(done)
+	}()
+	for i := 0; i < 3; i++ {
+		rtt, err := conn.Ping(context.Background())
+		if err != nil {
+			t.Error(err)
+		} else if rtt <= 0 {
+			t.Error(rtt)
+		}
+	}
+	if rtt := conn.RTT(); rtt.Samples < 3 || rtt.Smoothed <= 0 || rtt.Min > rtt.Max {
+		t.Error(rtt)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if rtt := server.RTT(); rtt.Samples == 0 {
+		t.Error(rtt)
+	}
+	conn.Close()
+	<-done
+	if _, err := conn.Ping(context.Background()); err == nil {
+		t.Error()
+	}
+	<-errs
+	l.Close()
+	wg.Wait()
+}
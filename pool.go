@@ -0,0 +1,55 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"github.com/hslam/buffer"
+)
+
+// BufferPool represents a pool of the read and write buffers.
+//
+// A BufferPool may be shared by many connections, so the methods must be
+// safe for concurrent use.
+type BufferPool interface {
+	// GetBuffer returns a buffer whose length is at least size.
+	GetBuffer(size int) []byte
+	// PutBuffer returns the buffer to the pool.
+	PutBuffer(buf []byte)
+}
+
+// DefaultBufferPool is the default BufferPool that is backed by the
+// size-classed pools of github.com/hslam/buffer.
+var DefaultBufferPool BufferPool = defaultBufferPool{}
+
+// largeBufferSize is the size from which github.com/hslam/buffer creates a
+// pool for each size aligned to 1KB, that is never freed. The larger buffers
+// are rounded up to a power of two, so that the number of pools is bounded.
+const largeBufferSize = 64 * 1024
+
+type defaultBufferPool struct{}
+
+func (defaultBufferPool) GetBuffer(size int) []byte {
+	if size < largeBufferSize {
+		return buffer.GetBuffer(size)
+	}
+	return buffer.GetBuffer(sizeClass(size))[:size]
+}
+
+func (defaultBufferPool) PutBuffer(buf []byte) {
+	if n := cap(buf); n >= largeBufferSize && n != sizeClass(n) {
+		// It has not been taken from the pool.
+		return
+	}
+	buffer.PutBuffer(buf)
+}
+
+// sizeClass returns the smallest power of two that is at least size and
+// largeBufferSize.
+func sizeClass(size int) int {
+	n := largeBufferSize
+	for n < size {
+		n <<= 1
+	}
+	return n
+}
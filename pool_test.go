@@ -0,0 +1,103 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type testBufferPool struct {
+	gets int64
+	puts int64
+}
+
+func (p *testBufferPool) GetBuffer(size int) []byte {
+	atomic.AddInt64(&p.gets, 1)
+	return DefaultBufferPool.GetBuffer(size)
+}
+
+func (p *testBufferPool) PutBuffer(buf []byte) {
+	atomic.AddInt64(&p.puts, 1)
+	DefaultBufferPool.PutBuffer(buf)
+}
+
+func TestBufferPool(t *testing.T) {
+	network := "tcp"
+	addr := ":8080"
+	pool := &testBufferPool{}
+	u := &Upgrader{BufferPool: pool}
+	done := make(chan struct{}, 2)
+	Serve := func(conn *Conn) {
+		for {
+			msg, err := conn.ReadMessage(nil)
+			if err != nil {
+				break
+			}
+			conn.WriteMessage(msg)
+		}
+		conn.Close()
+		done <- struct{}{}
+	}
+	httpServer := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := u.UpgradeHTTP(w, r)
+			if err == nil {
+				Serve(conn)
+			}
+		}),
+	}
+	l, _ := net.Listen(network, addr)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		httpServer.Serve(l)
+	}()
+	d := &Dialer{BufferPool: pool}
+	conn, err := d.Dial(network, addr, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := "Hello World"
+	if err := conn.WriteMessage([]byte(msg)); err != nil {
+		t.Error(err)
+	}
+	if data, err := conn.ReadMessage(nil); err != nil {
+		t.Error(err)
+	} else if string(data) != msg {
+		t.Error(string(data))
+	}
+	conn.Close()
+	<-done
+	httpServer.Close()
+	wg.Wait()
+	if gets, puts := atomic.LoadInt64(&pool.gets), atomic.LoadInt64(&pool.puts); gets == 0 || gets != puts {
+		t.Error(gets, puts)
+	}
+}
+
+func TestDefaultBufferPoolSizeClasses(t *testing.T) {
+	for _, c := range []struct{ size, class int }{
+		{1, 8},
+		{1000, 1024},
+		{bufferSize + maxHeaderBytes, 64 * 1024},
+		{64*1024 + 1, 128 * 1024},
+		{100*1024 + 14, 128 * 1024},
+		{1 << 20, 1 << 20},
+		{1<<20 + 1, 2 << 20},
+	} {
+		buf := DefaultBufferPool.GetBuffer(c.size)
+		if len(buf) != c.size || cap(buf) != c.class {
+			t.Error(c.size, len(buf), cap(buf))
+		}
+		DefaultBufferPool.PutBuffer(buf)
+	}
+	// A buffer that is not of a size class is not pooled.
+	DefaultBufferPool.PutBuffer(make([]byte, 100*1024))
+}
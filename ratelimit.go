@@ -0,0 +1,214 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimitPolicy represents what is done with a message that exceeds the
+// rate limit.
+type RateLimitPolicy int
+
+const (
+	// RateLimitDelay delays the next read until the messages that exceeded
+	// the limit have been paid off, which applies backpressure to the peer.
+	RateLimitDelay RateLimitPolicy = iota
+	// RateLimitDrop drops the message.
+	RateLimitDrop
+	// RateLimitClose closes the connection with the policy violation status
+	// code, and returns ErrRateLimited.
+	RateLimitClose
+)
+
+// ErrRateLimited is returned when the connection has been closed because the
+// peer exceeded the rate limit.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// errDropped is returned by limit when the message is dropped.
+var errDropped = errors.New("dropped")
+
+// bucket is a token bucket that holds up to one second of its rate, and can
+// be in debt of up to one second of its rate.
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   int64
+}
+
+func (b *bucket) refill(now int64) {
+	if b.rate <= 0 {
+		return
+	}
+	b.tokens += float64(now-b.last) / float64(time.Second) * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+// allow reports whether n tokens are available. A request larger than the
+// bucket is allowed when the bucket is full.
+func (b *bucket) allow(n float64) bool {
+	return b.wait(n) == 0
+}
+
+// wait returns how long to wait until n tokens are available. A request
+// larger than the bucket waits until the bucket is full.
+func (b *bucket) wait(n float64) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	if n > b.rate {
+		n = b.rate
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take takes n tokens. The debt is capped at the size of the bucket, so that
+// a large message does not stall the connection for more than one second.
+func (b *bucket) take(n float64) {
+	if b.rate <= 0 {
+		return
+	}
+	b.tokens -= n
+	if b.tokens < -b.rate {
+		b.tokens = -b.rate
+	}
+}
+
+type rateLimiter struct {
+	messages bucket
+	bytes    bucket
+	policy   RateLimitPolicy
+	delayed  bool
+}
+
+// SetRateLimit limits the messages read per second and the payload bytes read
+// per second, with token buckets that allow bursts of one second. A zero rate
+// is unlimited, and both zero rates disable the rate limit. The policy sets
+// what is done with a message that exceeds the limit.
+//
+// The rate limit applies to the messages read by ReadMessage, Borrow and the
+// related methods. The messages that exceed it are counted in the stats of
+// the connection. With RateLimitDelay, a message is read as soon as the
+// previous ones are within the limit, so the next read waits until they are
+// paid off, unless the connection is closed or the context of
+// ReadMessageContext is done. The reads of a non-blocking connection return
+// ErrWouldBlock instead of waiting, and should be retried later.
+func (c *Conn) SetRateLimit(messagesPerSecond, bytesPerSecond float64, policy RateLimitPolicy) {
+	if messagesPerSecond <= 0 && bytesPerSecond <= 0 {
+		if x := c.loadExtra(); x != nil {
+			x.rateLimit.Store((*rateLimiter)(nil))
+		}
+		return
+	}
+	now := time.Now().UnixNano()
+	c.getExtra().rateLimit.Store(&rateLimiter{
+		messages: bucket{rate: messagesPerSecond, tokens: messagesPerSecond, last: now},
+		bytes:    bucket{rate: bytesPerSecond, tokens: bytesPerSecond, last: now},
+		policy:   policy,
+	})
+}
+
+func (c *Conn) rateLimiter() *rateLimiter {
+	x := c.loadExtra()
+	if x == nil {
+		return nil
+	}
+	r, _ := x.rateLimit.Load().(*rateLimiter)
+	return r
+}
+
+// delay waits until the messages that have been read are within the rate
+// limit, when the policy is RateLimitDelay.
+func (c *Conn) delay() error {
+	r := c.rateLimiter()
+	if r == nil || r.policy != RateLimitDelay {
+		return nil
+	}
+	for {
+		now := time.Now().UnixNano()
+		r.messages.refill(now)
+		r.bytes.refill(now)
+		d := r.messages.wait(1)
+		if wait := r.bytes.wait(0); wait > d {
+			d = wait
+		}
+		if d <= 0 {
+			return nil
+		}
+		r.delayed = true
+		if atomic.LoadInt32(&c.nonBlocking) == 1 {
+			return ErrWouldBlock
+		}
+		var readDone <-chan struct{}
+		ctx := c.getExtra().readContext
+		if ctx != nil {
+			readDone = ctx.Done()
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-c.Context().Done():
+			timer.Stop()
+			if err := c.failure(); err != nil {
+				return err
+			}
+			return io.EOF
+		case <-readDone:
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// limit applies the rate limit to a message of n bytes that has been read.
+func (c *Conn) limit(n int) error {
+	r := c.rateLimiter()
+	if r == nil {
+		return nil
+	}
+	now := time.Now().UnixNano()
+	r.messages.refill(now)
+	r.bytes.refill(now)
+	if r.policy == RateLimitDelay {
+		// The next read waits until the message is paid off.
+		r.messages.take(1)
+		r.bytes.take(float64(n))
+		if r.delayed {
+			r.delayed = false
+			atomic.AddUint64(&c.stats.rateLimited, 1)
+			atomic.AddUint64(&global.rateLimited, 1)
+		}
+		return nil
+	}
+	if r.messages.allow(1) && r.bytes.allow(float64(n)) {
+		r.messages.take(1)
+		r.bytes.take(float64(n))
+		return nil
+	}
+	atomic.AddUint64(&c.stats.rateLimited, 1)
+	atomic.AddUint64(&global.rateLimited, 1)
+	switch r.policy {
+	case RateLimitDrop:
+		atomic.AddUint64(&c.stats.dropped, 1)
+		atomic.AddUint64(&global.dropped, 1)
+		return errDropped
+	case RateLimitClose:
+		if l := c.log(); l != nil {
+			l.Info("rate limit exceeded", "remote", remoteAddr(c.conn))
+		}
+		c.writeClose(ClosePolicyViolation, ErrRateLimited.Error())
+		c.fail(ErrRateLimited)
+		return ErrRateLimited
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	testRateLimit := func(messagesPerSecond, bytesPerSecond float64, policy RateLimitPolicy, msgs [][]byte) (server *Conn, received int, elapsed time.Duration, err error) {
+		errs := make(chan error, 1)
+		servers := make(chan *Conn, 1)
+		l, wg := testServe(t, func(conn *Conn) {
+			conn.SetRateLimit(messagesPerSecond, bytesPerSecond, policy)
+			servers <- conn
+		}, errs)
+		conn, err := Dial("tcp", ":8080", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server = <-servers
+		start := time.Now()
+		if _, err := conn.WriteMessages(msgs); err != nil {
+			t.Error(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Millisecond * 1500))
+		for received < len(msgs) {
+			if _, err = conn.ReadMessage(nil); err != nil {
+				break
+			}
+			received++
+		}
+		elapsed = time.Since(start)
+		conn.Close()
+		<-errs
+		l.Close()
+		wg.Wait()
+		return
+	}
+	msgs := make([][]byte, 15)
+	for i := range msgs {
+		msgs[i] = []byte{byte(i)}
+	}
+
+	server, received, elapsed, _ := testRateLimit(10, 0, RateLimitDelay, msgs)
+	if received != 15 || elapsed < time.Millisecond*400 {
+		t.Error(received, elapsed)
+	}
+	if stats := server.Stats(); stats.RateLimited != 5 || stats.DroppedMessages != 0 {
+		t.Error(stats.RateLimited, stats.DroppedMessages)
+	}
+
+	server, received, _, _ = testRateLimit(10, 0, RateLimitDrop, msgs)
+	if received != 10 {
+		t.Error(received)
+	}
+	if stats := server.Stats(); stats.RateLimited != 5 || stats.DroppedMessages != 5 {
+		t.Error(stats.RateLimited, stats.DroppedMessages)
+	}
+
+	server, received, _, err := testRateLimit(10, 0, RateLimitClose, msgs)
+	if received != 10 {
+		t.Error(received)
+	}
+	if closeErr, ok := err.(*CloseError); !ok || closeErr.Code != ClosePolicyViolation {
+		t.Error(err)
+	}
+	if stats := server.Stats(); stats.RateLimited != 1 || stats.CloseCodesOut[ClosePolicyViolation] != 1 {
+		t.Error(stats.RateLimited, stats.CloseCodesOut)
+	}
+
+	// The fifth message of 2500 bytes puts the bucket of 10000 bytes in debt,
+	// so the next three messages wait 250ms each.
+	msgs = make([][]byte, 8)
+	for i := range msgs {
+		msgs[i] = make([]byte, 2500)
+	}
+	server, received, elapsed, _ = testRateLimit(0, 10000, RateLimitDelay, msgs)
+	if received != 8 || elapsed < time.Millisecond*700 {
+		t.Error(received, elapsed)
+	}
+	if stats := server.Stats(); stats.RateLimited != 3 {
+		t.Error(stats.RateLimited)
+	}
+
+	// The debt of a large message is capped at one second.
+	msgs = [][]byte{make([]byte, 50000), {1}}
+	server, received, elapsed, _ = testRateLimit(0, 10000, RateLimitDelay, msgs)
+	if received != 2 || elapsed < time.Millisecond*900 {
+		t.Error(received, elapsed)
+	}
+	if stats := server.Stats(); stats.RateLimited != 1 {
+		t.Error(stats.RateLimited)
+	}
+}
+
+func TestRateLimitNonBlocking(t *testing.T) {
+	var data []byte
+	for i := 0; i < 11; i++ {
+		f := &frame{FIN: 1, Opcode: BinaryFrame, Mask: 1, MaskingKey: []byte{1, 2, 3, 4}, PayloadData: []byte{byte(i)}}
+		b, _ := f.Marshal(nil)
+		data = append(data, b...)
+	}
+	conn := server(&testSegmentConn{data: data, segment: len(data)}, "")
+	conn.SetRateLimit(10, 0, RateLimitDelay)
+	conn.SetNonBlocking(true)
+	for i := 0; i < 10; i++ {
+		if p, err := conn.ReadMessage(nil); err != nil {
+			t.Error(err)
+		} else if len(p) != 1 || p[0] != byte(i) {
+			t.Error(p)
+		}
+	}
+	if _, err := conn.ReadMessage(nil); err != ErrWouldBlock {
+		t.Error(err)
+	}
+	time.Sleep(time.Millisecond * 150)
+	if p, err := conn.ReadMessage(nil); err != nil {
+		t.Error(err)
+	} else if len(p) != 1 || p[0] != 10 {
+		t.Error(p)
+	}
+	if stats := conn.Stats(); stats.RateLimited != 1 {
+		t.Error(stats.RateLimited)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net/http"
+)
+
+// Request returns a copy of the handshake request of a server connection,
+// with its method, URL, headers and remote address, and without a body.
+// Its context has the values of the context of the request, but not its
+// deadline nor its cancellation. It returns nil for a client connection.
+// The request and its headers are shared by all the calls of Request, and
+// with the request passed to UpgradeHTTP, so do not modify them.
+func (c *Conn) Request() *http.Request {
+	return c.request
+}
+
+// Subprotocol returns the subprotocol negotiated during the handshake, or an
+// empty string if none.
+//
+// No extensions are negotiated by this package, so the connection has none;
+// the extensions requested by a client can be found in the
+// Sec-WebSocket-Extensions header of the request.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// copyRequest returns a read-only shallow copy of the handshake request,
+// which is kept by the connection.
+func copyRequest(r *http.Request) *http.Request {
+	req := r.WithContext(valuesContext{r.Context()})
+	req.Body = http.NoBody
+	req.GetBody = nil
+	return req
+}
+
+// selectSubprotocol returns the first subprotocol of the upgrader that has
+// been requested by the client.
+func (u *Upgrader) selectSubprotocol(r *http.Request) string {
+	if len(u.Subprotocols) == 0 {
+		return ""
+	}
+	requested := subprotocols(r)
+	for _, protocol := range u.Subprotocols {
+		if contains(requested, protocol) {
+			return protocol
+		}
+	}
+	return ""
+}
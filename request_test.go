@@ -0,0 +1,62 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestRequest(t *testing.T) {
+	l, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := Upgrader{Subprotocols: []string{"chat", "superchat"}}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ws, err := u.Upgrade(conn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer ws.Close()
+		r := ws.Request()
+		if r.Method != "GET" || r.URL.Path != "/chat" || r.URL.Query().Get("room") != "1" {
+			t.Error(r.Method, r.URL)
+		}
+		if r.Host != ":8080" || r.RemoteAddr != conn.RemoteAddr().String() {
+			t.Error(r.Host, r.RemoteAddr)
+		}
+		if r.Header.Get("Sec-WebSocket-Version") != "13" {
+			t.Error(r.Header)
+		}
+		if ws.Subprotocol() != "chat" {
+			t.Error(ws.Subprotocol())
+		}
+		ws.ReadMessage(nil)
+	}()
+	d := Dialer{Subprotocols: []string{"superchat", "chat"}}
+	conn, err := d.Dial("tcp", ":8080", "/chat?room=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.Subprotocol() != "chat" {
+		t.Error(conn.Subprotocol())
+	}
+	if conn.Request() != nil {
+		t.Error()
+	}
+	conn.Close()
+	wg.Wait()
+	l.Close()
+}
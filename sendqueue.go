@@ -0,0 +1,239 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// SendPolicy represents what Send does when the send queue is full.
+type SendPolicy int
+
+const (
+	// SendBlock blocks Send until the queue has room.
+	SendBlock SendPolicy = iota
+	// SendDropOldest drops the oldest queued message to make room.
+	SendDropOldest
+	// SendDropNewest drops the message being sent, and returns ErrQueueFull.
+	SendDropNewest
+	// SendClose closes the connection with the policy violation status code,
+	// and returns ErrQueueFull.
+	SendClose
+)
+
+// ErrQueueFull is returned by Send when the message has not been queued
+// because the send queue is full.
+var ErrQueueFull = errors.New("send queue full")
+
+// sendQueue is a bounded queue of messages drained by a writer goroutine,
+// which only runs while the queue is not empty.
+type sendQueue struct {
+	lock     sync.Mutex
+	cond     sync.Cond
+	messages [][]byte
+	size     int
+	policy   SendPolicy
+	running  bool
+	closed   bool
+}
+
+// SetSendQueue makes Send queue up to size messages, that are written by a
+// writer goroutine, so that a slow peer does not block the sender. The policy
+// sets what Send does when the queue is full. A size of zero or less makes
+// Send write synchronously again, once the queued messages are written.
+//
+// The control frames, such as the pings and the close frames, are not
+// queued. They are written as soon as the message in flight is written.
+func (c *Conn) SetSendQueue(size int, policy SendPolicy) {
+	if size < 0 {
+		size = 0
+	}
+	x := c.getExtra()
+	x.lock.Lock()
+	q := x.sendQueue
+	if q == nil && size > 0 {
+		q = &sendQueue{}
+		q.cond.L = &q.lock
+		x.sendQueue = q
+	}
+	x.lock.Unlock()
+	if q == nil {
+		return
+	}
+	q.lock.Lock()
+	q.size = size
+	q.policy = policy
+	q.cond.Broadcast()
+	q.lock.Unlock()
+}
+
+// Send queues a binary message to be written by the writer goroutine of the
+// send queue, or writes it like WriteMessage if there is no send queue. The
+// message is not copied, so b must not be modified after Send returns.
+// A write error closes the connection, and is returned by the next Send.
+func (c *Conn) Send(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	q := c.loadSendQueue()
+	if q == nil {
+		return c.WriteMessage(b)
+	}
+	q.lock.Lock()
+	for {
+		if q.closed {
+			q.lock.Unlock()
+			return c.sendError()
+		}
+		if q.size == 0 {
+			if len(q.messages) == 0 && !q.running {
+				q.lock.Unlock()
+				return c.WriteMessage(b)
+			}
+		} else if len(q.messages) < q.size {
+			break
+		} else {
+			switch q.policy {
+			case SendDropOldest:
+				q.messages[0] = nil
+				q.messages = q.messages[1:]
+				c.dequeuedStats(true)
+				continue
+			case SendDropNewest:
+				q.lock.Unlock()
+				c.droppedStats()
+				return ErrQueueFull
+			case SendClose:
+				q.closed = true
+				q.lock.Unlock()
+				c.droppedStats()
+				if l := c.log(); l != nil {
+					l.Info("send queue full", "remote", remoteAddr(c.conn))
+				}
+				go c.closeSendQueue(q)
+				return ErrQueueFull
+			}
+		}
+		q.cond.Wait()
+	}
+	q.messages = append(q.messages, b)
+	c.queuedStats()
+	if !q.running {
+		q.running = true
+		go c.drain(q)
+	}
+	q.lock.Unlock()
+	return nil
+}
+
+// SendQueueLen returns the number of messages waiting in the send queue,
+// not counting the message in flight.
+func (c *Conn) SendQueueLen() int {
+	q := c.loadSendQueue()
+	if q == nil {
+		return 0
+	}
+	q.lock.Lock()
+	n := len(q.messages)
+	q.lock.Unlock()
+	return n
+}
+
+// loadSendQueue returns the send queue of the connection, or nil.
+func (c *Conn) loadSendQueue() (q *sendQueue) {
+	if x := c.loadExtra(); x != nil {
+		x.lock.Lock()
+		q = x.sendQueue
+		x.lock.Unlock()
+	}
+	return
+}
+
+// drain writes the queued messages until the queue is empty.
+func (c *Conn) drain(q *sendQueue) {
+	for {
+		q.lock.Lock()
+		if q.closed || len(q.messages) == 0 {
+			q.running = false
+			q.cond.Broadcast()
+			q.lock.Unlock()
+			return
+		}
+		b := q.messages[0]
+		q.messages[0] = nil
+		q.messages = q.messages[1:]
+		c.dequeuedStats(false)
+		q.cond.Broadcast()
+		q.lock.Unlock()
+		if err := c.WriteMessage(b); err != nil {
+			q.lock.Lock()
+			q.running = false
+			q.lock.Unlock()
+			c.fail(err)
+			return
+		}
+	}
+}
+
+// closeSendQueue waits for the message in flight, and closes the connection
+// with the policy violation status code.
+func (c *Conn) closeSendQueue(q *sendQueue) {
+	q.lock.Lock()
+	for q.running {
+		q.cond.Wait()
+	}
+	q.lock.Unlock()
+	c.writeClose(ClosePolicyViolation, ErrQueueFull.Error())
+	c.fail(ErrQueueFull)
+}
+
+// stopSendQueue drops the queued messages, and wakes up the blocked senders.
+func (c *Conn) stopSendQueue() {
+	q := c.loadSendQueue()
+	if q == nil {
+		return
+	}
+	q.lock.Lock()
+	q.closed = true
+	for i := range q.messages {
+		q.messages[i] = nil
+		c.dequeuedStats(true)
+	}
+	q.messages = nil
+	q.cond.Broadcast()
+	q.lock.Unlock()
+}
+
+// sendError returns the reason why the send queue has been closed.
+func (c *Conn) sendError() error {
+	if err := c.failure(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// queuedStats records a message that has been queued.
+func (c *Conn) queuedStats() {
+	atomic.AddInt64(&c.stats.queued, 1)
+	atomic.AddInt64(&global.queued, 1)
+}
+
+// dequeuedStats records a message that has left the queue, either written
+// or dropped.
+func (c *Conn) dequeuedStats(dropped bool) {
+	atomic.AddInt64(&c.stats.queued, -1)
+	atomic.AddInt64(&global.queued, -1)
+	if dropped {
+		c.droppedStats()
+	}
+}
+
+// droppedStats records a message that has been dropped by the send queue.
+func (c *Conn) droppedStats() {
+	atomic.AddUint64(&c.stats.sendDropped, 1)
+	atomic.AddUint64(&global.sendDropped, 1)
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSendQueue(t *testing.T) {
+	errs := make(chan error, 1)
+	servers := make(chan *Conn, 1)
+	l, wg := testServe(t, func(conn *Conn) {
+		conn.SetSendQueue(2, SendDropNewest)
+		servers <- conn
+	}, errs)
+	conn, err := Dial("tcp", ":8080", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-servers
+	message := func(i byte) []byte {
+		msg := make([]byte, 16<<20)
+		msg[0] = i
+		return msg
+	}
+	// The first message is in flight, since the client does not read.
+	inFlight := func(i byte) {
+		if err := server.Send(message(i)); err != nil {
+			t.Error(err)
+		}
+		for server.SendQueueLen() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	receive := func(want ...byte) {
+		for _, i := range want {
+			msg, err := conn.ReadMessage(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg[0] != i {
+				t.Error(msg[0], i)
+			}
+		}
+	}
+	inFlight(1)
+	server.Send(message(2))
+	server.Send(message(3))
+	if err := server.Send(message(4)); err != ErrQueueFull {
+		t.Error(err)
+	}
+	if n := server.SendQueueLen(); n != 2 {
+		t.Error(n)
+	}
+	if stats := server.Stats(); stats.QueuedMessages != 2 || stats.SendDropped != 1 {
+		t.Error(stats.QueuedMessages, stats.SendDropped)
+	}
+	server.SetSendQueue(2, SendDropOldest)
+	if err := server.Send(message(5)); err != nil {
+		t.Error(err)
+	}
+	if stats := server.Stats(); stats.QueuedMessages != 2 || stats.SendDropped != 2 {
+		t.Error(stats.QueuedMessages, stats.SendDropped)
+	}
+	receive(1, 3, 5)
+
+	server.SetSendQueue(1, SendBlock)
+	inFlight(6)
+	server.Send(message(7))
+	sent := make(chan error, 1)
+	go func() {
+		sent <- server.Send(message(8))
+	}()
+	select {
+	case err := <-sent:
+		t.Error(err)
+	case <-time.After(time.Millisecond * 50):
+	}
+	receive(6)
+	if err := <-sent; err != nil {
+		t.Error(err)
+	}
+	receive(7, 8)
+
+	server.SetSendQueue(1, SendClose)
+	inFlight(9)
+	server.Send(message(10))
+	if err := server.Send(message(11)); err != ErrQueueFull {
+		t.Error(err)
+	}
+	receive(9)
+	if _, err := conn.ReadMessage(nil); err == nil {
+		t.Error()
+	}
+	if code := conn.Stats().CloseCodesIn; code[ClosePolicyViolation] != 1 {
+		t.Error(code)
+	}
+	if err := server.Send(message(12)); err != ErrQueueFull {
+		t.Error(err)
+	}
+	if stats := server.Stats(); stats.QueuedMessages != 0 {
+		t.Error(stats.QueuedMessages)
+	}
+	conn.Close()
+	<-errs
+	l.Close()
+	wg.Wait()
+}
@@ -0,0 +1,246 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by the Serve and ListenAndServe methods of the
+// Server after a call to Shutdown or Close.
+var ErrServerClosed = errors.New("server closed")
+
+// Server serves WebSocket connections, and keeps a registry of the live
+// connections so that they can be closed gracefully on Shutdown.
+//
+// A Server can accept the connections itself with Serve and ListenAndServe,
+// or be used as the http.Handler of an http.Server, whose own Shutdown
+// ignores the hijacked connections.
+type Server struct {
+	// Addr optionally specifies the TCP address to listen on for
+	// ListenAndServe, ":80" if empty.
+	Addr string
+	// Handler is called with each connection. The connection is closed when
+	// the handler returns.
+	Handler func(*Conn)
+	// Upgrader upgrades the connections to the WebSocket protocol.
+	Upgrader Upgrader
+
+	lock      sync.Mutex
+	conns     map[*Conn]struct{}
+	listeners map[net.Listener]struct{}
+	idle      chan struct{}
+	closed    bool
+}
+
+// ListenAndServe listens on the TCP network address s.Addr and then calls
+// Serve to handle the incoming connections.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":80"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts the incoming connections on the listener l, upgrades them
+// and calls s.Handler for each one. Serve always returns a non-nil error,
+// and ErrServerClosed after Shutdown or Close.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.trackListener(l, true) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.trackListener(l, false)
+	var tempDelay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				// Back off like net/http, so that a temporary error such as
+				// too many open files does not spin.
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				if logger := s.Upgrader.log(); logger != nil {
+					logger.Warn("accept error", "error", err, "retry", tempDelay)
+				}
+				time.Sleep(tempDelay)
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+		go func(conn net.Conn) {
+			ws, err := s.Upgrader.Upgrade(conn)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			s.serve(ws)
+		}(conn)
+	}
+}
+
+// ServeHTTP implements the http.Handler interface. It upgrades the request
+// and calls s.Handler with the connection.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.shuttingDown() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, "503 server shutting down\n")
+		return
+	}
+	conn, err := s.Upgrader.UpgradeHTTP(w, r)
+	if err == nil {
+		s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn *Conn) {
+	if !s.trackConn(conn) {
+		conn.writeClose(CloseGoingAway, "server shutting down")
+		conn.Close()
+		return
+	}
+	if s.Handler != nil {
+		s.Handler(conn)
+	}
+	conn.Close()
+}
+
+// Len returns the number of the live connections.
+func (s *Server) Len() int {
+	s.lock.Lock()
+	n := len(s.conns)
+	s.lock.Unlock()
+	return n
+}
+
+// Shutdown gracefully shuts down the server. It stops accepting connections,
+// sends a close frame with the going away status code to every connection,
+// and waits for the closing handshakes to complete until the context is
+// done. Then it closes the remaining connections, and returns the error of
+// the context.
+//
+// The closing handshake of a connection completes when its handler reads the
+// close frame of the peer, or returns.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	s.closed = true
+	s.closeListenersLocked()
+	conns := make([]*Conn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	if s.idle == nil {
+		s.idle = make(chan struct{})
+		if len(s.conns) == 0 {
+			close(s.idle)
+		}
+	}
+	idle := s.idle
+	s.lock.Unlock()
+	for _, conn := range conns {
+		go conn.writeClose(CloseGoingAway, "server shutting down")
+	}
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+// Close immediately closes the listeners and all the connections of the server.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	s.closed = true
+	s.closeListenersLocked()
+	conns := make([]*Conn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.lock.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return nil
+}
+
+func (s *Server) shuttingDown() bool {
+	s.lock.Lock()
+	closed := s.closed
+	s.lock.Unlock()
+	return closed
+}
+
+func (s *Server) closeListenersLocked() {
+	for l := range s.listeners {
+		l.Close()
+		delete(s.listeners, l)
+	}
+}
+
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if add {
+		if s.closed {
+			return false
+		}
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]struct{})
+		}
+		s.listeners[l] = struct{}{}
+	} else {
+		delete(s.listeners, l)
+	}
+	return true
+}
+
+// trackConn adds the connection to the registry, and removes it when the
+// connection is closed.
+func (s *Server) trackConn(conn *Conn) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[*Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	conn.onClose = func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		if s.idle != nil && len(s.conns) == 0 {
+			select {
+			case <-s.idle:
+			default:
+				close(s.idle)
+			}
+		}
+		s.lock.Unlock()
+	}
+	return true
+}
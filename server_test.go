@@ -0,0 +1,122 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServerShutdown(t *testing.T) {
+	testShutdown := func(read bool) (closeErr error, err error) {
+		s := &Server{Handler: func(conn *Conn) {
+			for {
+				msg, err := conn.ReadMessage(nil)
+				if err != nil {
+					break
+				}
+				conn.WriteMessage(msg)
+			}
+		}}
+		l, err := net.Listen("tcp", ":8080")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Serve(l); err != ErrServerClosed {
+				t.Error(err)
+			}
+		}()
+		conn, err := Dial("tcp", ":8080", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.WriteMessage([]byte("Hello")); err != nil {
+			t.Error(err)
+		}
+		if _, err := conn.ReadMessage(nil); err != nil {
+			t.Error(err)
+		}
+		if s.Len() != 1 {
+			t.Error(s.Len())
+		}
+		done := make(chan struct{})
+		if read {
+			go func() {
+				_, closeErr = conn.ReadMessage(nil)
+				close(done)
+			}()
+		} else {
+			close(done)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+		err = s.Shutdown(ctx)
+		cancel()
+		<-done
+		if s.Len() != 0 {
+			t.Error(s.Len())
+		}
+		if _, err := Dial("tcp", ":8080", "/", nil); err == nil {
+			t.Error()
+		}
+		conn.Close()
+		wg.Wait()
+		return
+	}
+
+	closeErr, err := testShutdown(true)
+	if err != nil {
+		t.Error(err)
+	}
+	if e, ok := closeErr.(*CloseError); !ok || e.Code != CloseGoingAway {
+		t.Error(closeErr)
+	}
+	if _, err := testShutdown(false); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+}
+
+type testTemporaryError struct{}
+
+func (testTemporaryError) Error() string   { return "too many open files" }
+func (testTemporaryError) Timeout() bool   { return false }
+func (testTemporaryError) Temporary() bool { return true }
+
+type testFailingListener struct {
+	net.Listener
+	failures int
+	accepts  int
+}
+
+func (l *testFailingListener) Accept() (net.Conn, error) {
+	l.accepts++
+	if l.accepts > l.failures {
+		return nil, errors.New("closed")
+	}
+	return nil, testTemporaryError{}
+}
+
+func (l *testFailingListener) Close() error {
+	return nil
+}
+
+func TestServerAcceptBackoff(t *testing.T) {
+	s := &Server{}
+	l := &testFailingListener{failures: 4}
+	start := time.Now()
+	if err := s.Serve(l); err == nil || err.Error() != "closed" {
+		t.Error(err)
+	}
+	// 5ms, 10ms, 20ms and 40ms
+	if d := time.Since(start); d < time.Millisecond*75 {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// writeStallThreshold is the duration after which a frame write is counted
+// as a write stall.
+const writeStallThreshold = 10 * time.Millisecond
+
+// numOpcodes is the number of the defined opcodes.
+const numOpcodes = 6
+
+// opcodeNames are the names of the defined opcodes, by counter index.
+var opcodeNames = [numOpcodes]string{"continuation", "text", "binary", "close", "ping", "pong"}
+
+// OpcodeCounts represents a counter for each defined opcode.
+type OpcodeCounts struct {
+	Continuation uint64
+	Text         uint64
+	Binary       uint64
+	Close        uint64
+	Ping         uint64
+	Pong         uint64
+}
+
+// Total returns the sum of the counters.
+func (o OpcodeCounts) Total() uint64 {
+	return o.Continuation + o.Text + o.Binary + o.Close + o.Ping + o.Pong
+}
+
+// Control returns the sum of the counters of the control frames.
+func (o OpcodeCounts) Control() uint64 {
+	return o.Close + o.Ping + o.Pong
+}
+
+func (o *OpcodeCounts) load(counts *[numOpcodes]uint64) {
+	o.Continuation = atomic.LoadUint64(&counts[0])
+	o.Text = atomic.LoadUint64(&counts[1])
+	o.Binary = atomic.LoadUint64(&counts[2])
+	o.Close = atomic.LoadUint64(&counts[3])
+	o.Ping = atomic.LoadUint64(&counts[4])
+	o.Pong = atomic.LoadUint64(&counts[5])
+}
+
+func (o OpcodeCounts) values() [numOpcodes]uint64 {
+	return [numOpcodes]uint64{o.Continuation, o.Text, o.Binary, o.Close, o.Ping, o.Pong}
+}
+
+// Stats represents the traffic statistics of a connection, or of all the
+// connections of the process.
+//
+// Bytes count the payload bytes of the frames. A write stall is a frame
+// write that took longer than 10ms. RateLimited counts the messages read
+// that exceeded the rate limit, and DroppedMessages those of them that have
+// been dropped. QueuedMessages is the number of messages waiting in the send
+// queues, and SendDropped counts the messages dropped by the send queues.
+// Connections and ActiveConnections are only set in the process-wide
+// statistics.
+type Stats struct {
+	FramesIn          OpcodeCounts
+	FramesOut         OpcodeCounts
+	BytesIn           OpcodeCounts
+	BytesOut          OpcodeCounts
+	MessagesIn        uint64
+	MessagesOut       uint64
+	ControlFramesIn   uint64
+	ControlFramesOut  uint64
+	WriteStalls       uint64
+	RateLimited       uint64
+	DroppedMessages   uint64
+	QueuedMessages    int64
+	SendDropped       uint64
+	CloseCodesIn      map[int]uint64
+	CloseCodesOut     map[int]uint64
+	Connections       uint64
+	ActiveConnections int64
+}
+
+// counters holds the statistics counters. The 64-bit words come first,
+// so that they are aligned for the atomic operations.
+type counters struct {
+	framesIn     [numOpcodes]uint64
+	framesOut    [numOpcodes]uint64
+	bytesIn      [numOpcodes]uint64
+	bytesOut     [numOpcodes]uint64
+	messagesIn   uint64
+	messagesOut  uint64
+	writeStalls  uint64
+	rateLimited  uint64
+	dropped      uint64
+	sendDropped  uint64
+	queued       int64
+	closeCodeIn  int32
+	closeCodeOut int32
+	open         int32
+}
+
+// global holds the process-wide statistics.
+var global struct {
+	counters
+	connections   uint64
+	active        int64
+	lock          sync.Mutex
+	closeCodesIn  map[int]uint64
+	closeCodesOut map[int]uint64
+}
+
+func opcodeIndex(opcode byte) int {
+	switch {
+	case opcode <= BinaryFrame:
+		return int(opcode)
+	case opcode >= CloseFrame && opcode <= PongFrame:
+		return int(opcode-CloseFrame) + 3
+	}
+	return -1
+}
+
+func (s *counters) frameIn(opcode byte, length int) {
+	if i := opcodeIndex(opcode); i >= 0 {
+		atomic.AddUint64(&s.framesIn[i], 1)
+		atomic.AddUint64(&s.bytesIn[i], uint64(length))
+	}
+}
+
+func (s *counters) frameOut(opcode byte, length int) {
+	if i := opcodeIndex(opcode); i >= 0 {
+		atomic.AddUint64(&s.framesOut[i], 1)
+		atomic.AddUint64(&s.bytesOut[i], uint64(length))
+	}
+}
+
+func (s *counters) load(stats *Stats) {
+	stats.FramesIn.load(&s.framesIn)
+	stats.FramesOut.load(&s.framesOut)
+	stats.BytesIn.load(&s.bytesIn)
+	stats.BytesOut.load(&s.bytesOut)
+	stats.MessagesIn = atomic.LoadUint64(&s.messagesIn)
+	stats.MessagesOut = atomic.LoadUint64(&s.messagesOut)
+	stats.ControlFramesIn = stats.FramesIn.Control()
+	stats.ControlFramesOut = stats.FramesOut.Control()
+	stats.WriteStalls = atomic.LoadUint64(&s.writeStalls)
+	stats.RateLimited = atomic.LoadUint64(&s.rateLimited)
+	stats.DroppedMessages = atomic.LoadUint64(&s.dropped)
+	stats.QueuedMessages = atomic.LoadInt64(&s.queued)
+	stats.SendDropped = atomic.LoadUint64(&s.sendDropped)
+}
+
+// readFrameStats records a frame that has been read.
+func (c *Conn) readFrameStats(f *frame) {
+	c.stats.frameIn(f.Opcode, len(f.PayloadData))
+	global.frameIn(f.Opcode, len(f.PayloadData))
+}
+
+// wroteFrameStats records a frame that has been written.
+func (c *Conn) wroteFrameStats(opcode byte, fin byte, length int, d time.Duration) {
+	c.stats.frameOut(opcode, length)
+	global.frameOut(opcode, length)
+	if fin == 1 && opcode < CloseFrame {
+		atomic.AddUint64(&c.stats.messagesOut, 1)
+		atomic.AddUint64(&global.messagesOut, 1)
+	}
+	if d >= writeStallThreshold {
+		atomic.AddUint64(&c.stats.writeStalls, 1)
+		atomic.AddUint64(&global.writeStalls, 1)
+	}
+}
+
+// readMessageStats records a message that has been read.
+func (c *Conn) readMessageStats() {
+	atomic.AddUint64(&c.stats.messagesIn, 1)
+	atomic.AddUint64(&global.messagesIn, 1)
+}
+
+// closeCodeStats records the status code of a close frame.
+func (c *Conn) closeCodeStats(code int, in bool) {
+	global.lock.Lock()
+	if in {
+		atomic.StoreInt32(&c.stats.closeCodeIn, int32(code))
+		if global.closeCodesIn == nil {
+			global.closeCodesIn = make(map[int]uint64)
+		}
+		global.closeCodesIn[code]++
+	} else {
+		atomic.StoreInt32(&c.stats.closeCodeOut, int32(code))
+		if global.closeCodesOut == nil {
+			global.closeCodesOut = make(map[int]uint64)
+		}
+		global.closeCodesOut[code]++
+	}
+	global.lock.Unlock()
+}
+
+// openStats records an established connection.
+func (c *Conn) openStats() {
+	if atomic.CompareAndSwapInt32(&c.stats.open, 0, 1) {
+		atomic.AddUint64(&global.connections, 1)
+		atomic.AddInt64(&global.active, 1)
+	}
+}
+
+// closeStats records a closed connection.
+func (c *Conn) closeStats() {
+	if atomic.CompareAndSwapInt32(&c.stats.open, 1, 0) {
+		atomic.AddInt64(&global.active, -1)
+	}
+}
+
+// Stats returns the traffic statistics of the connection.
+func (c *Conn) Stats() Stats {
+	var stats Stats
+	c.stats.load(&stats)
+	stats.CloseCodesIn = make(map[int]uint64)
+	stats.CloseCodesOut = make(map[int]uint64)
+	if code := atomic.LoadInt32(&c.stats.closeCodeIn); code != 0 {
+		stats.CloseCodesIn[int(code)] = 1
+	}
+	if code := atomic.LoadInt32(&c.stats.closeCodeOut); code != 0 {
+		stats.CloseCodesOut[int(code)] = 1
+	}
+	return stats
+}
+
+// GlobalStats returns the traffic statistics of all the connections of the
+// process. They can be published through expvar by importing the
+// github.com/hslam/websocket/expvar package.
+func GlobalStats() Stats {
+	var stats Stats
+	global.load(&stats)
+	stats.Connections = atomic.LoadUint64(&global.connections)
+	stats.ActiveConnections = atomic.LoadInt64(&global.active)
+	stats.CloseCodesIn = make(map[int]uint64)
+	stats.CloseCodesOut = make(map[int]uint64)
+	global.lock.Lock()
+	for code, n := range global.closeCodesIn {
+		stats.CloseCodesIn[code] = n
+	}
+	for code, n := range global.closeCodesOut {
+		stats.CloseCodesOut[code] = n
+	}
+	global.lock.Unlock()
+	return stats
+}
+
+// MetricsHandler returns a handler that serves the process-wide statistics
+// in the Prometheus text exposition format.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w, GlobalStats())
+	})
+}
+
+func writeMetrics(w http.ResponseWriter, stats Stats) {
+	b := bufio.NewWriter(w)
+	counter := func(name, help string) {
+		fmt.Fprintf(b, "# HELP websocket_%s %s\n# TYPE websocket_%s counter\n", name, help, name)
+	}
+	opcodes := func(name, help string, in, out OpcodeCounts) {
+		counter(name, help)
+		for direction, counts := range [2]OpcodeCounts{in, out} {
+			values := counts.values()
+			for i, value := range values {
+				fmt.Fprintf(b, "websocket_%s{direction=%q,opcode=%q} %d\n", name, directionNames[direction], opcodeNames[i], value)
+			}
+		}
+	}
+	opcodes("frames_total", "Frames read and written.", stats.FramesIn, stats.FramesOut)
+	opcodes("payload_bytes_total", "Payload bytes read and written.", stats.BytesIn, stats.BytesOut)
+	counter("messages_total", "Messages read and written.")
+	fmt.Fprintf(b, "websocket_messages_total{direction=\"in\"} %d\n", stats.MessagesIn)
+	fmt.Fprintf(b, "websocket_messages_total{direction=\"out\"} %d\n", stats.MessagesOut)
+	counter("control_frames_total", "Control frames read and written.")
+	fmt.Fprintf(b, "websocket_control_frames_total{direction=\"in\"} %d\n", stats.ControlFramesIn)
+	fmt.Fprintf(b, "websocket_control_frames_total{direction=\"out\"} %d\n", stats.ControlFramesOut)
+	counter("write_stalls_total", "Frame writes that took longer than 10ms.")
+	fmt.Fprintf(b, "websocket_write_stalls_total %d\n", stats.WriteStalls)
+	counter("rate_limited_messages_total", "Messages read that exceeded the rate limit.")
+	fmt.Fprintf(b, "websocket_rate_limited_messages_total %d\n", stats.RateLimited)
+	counter("dropped_messages_total", "Messages read that have been dropped by the rate limit.")
+	fmt.Fprintf(b, "websocket_dropped_messages_total %d\n", stats.DroppedMessages)
+	counter("send_dropped_messages_total", "Messages dropped by the send queues.")
+	fmt.Fprintf(b, "websocket_send_dropped_messages_total %d\n", stats.SendDropped)
+	fmt.Fprintf(b, "# HELP websocket_send_queue_messages Messages waiting in the send queues.\n# TYPE websocket_send_queue_messages gauge\n")
+	fmt.Fprintf(b, "websocket_send_queue_messages %d\n", stats.QueuedMessages)
+	counter("close_codes_total", "Close frames read and written, by status code.")
+	for direction, codes := range [2]map[int]uint64{stats.CloseCodesIn, stats.CloseCodesOut} {
+		keys := make([]int, 0, len(codes))
+		for code := range codes {
+			keys = append(keys, code)
+		}
+		sort.Ints(keys)
+		for _, code := range keys {
+			fmt.Fprintf(b, "websocket_close_codes_total{direction=%q,code=\"%d\"} %d\n", directionNames[direction], code, codes[code])
+		}
+	}
+	counter("connections_total", "Connections established.")
+	fmt.Fprintf(b, "websocket_connections_total %d\n", stats.Connections)
+	fmt.Fprintf(b, "# HELP websocket_connections Connections open.\n# TYPE websocket_connections gauge\n")
+	fmt.Fprintf(b, "websocket_connections %d\n", stats.ActiveConnections)
+	b.Flush()
+}
+
+var directionNames = [2]string{"in", "out"}
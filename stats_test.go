@@ -0,0 +1,77 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	errs := make(chan error, 1)
+	l, wg := testServe(t, nil, errs)
+	before := GlobalStats()
+	conn, err := Dial("tcp", ":8080", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteTextMessage("Hello"); err != nil {
+		t.Error(err)
+	}
+	if err := conn.WriteMessage([]byte{1, 2, 3}); err != nil {
+		t.Error(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := conn.ReadMessageType(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	conn.writeClose(CloseNormalClosure, "")
+	if _, err := conn.ReadMessage(nil); err == nil {
+		t.Error()
+	}
+	conn.Close()
+	<-errs
+	l.Close()
+	wg.Wait()
+
+	stats := conn.Stats()
+	if stats.MessagesOut != 2 || stats.MessagesIn != 2 {
+		t.Error(stats.MessagesOut, stats.MessagesIn)
+	}
+	if stats.FramesOut.Text != 1 || stats.FramesOut.Binary != 1 || stats.FramesOut.Close != 1 {
+		t.Error(stats.FramesOut)
+	}
+	if stats.BytesOut.Text != 5 || stats.BytesOut.Binary != 3 || stats.BytesIn.Total() != 10 {
+		t.Error(stats.BytesOut, stats.BytesIn)
+	}
+	if stats.ControlFramesOut != 1 || stats.ControlFramesIn != 1 {
+		t.Error(stats.ControlFramesOut, stats.ControlFramesIn)
+	}
+	if stats.CloseCodesOut[CloseNormalClosure] != 1 || stats.CloseCodesIn[CloseNormalClosure] != 1 {
+		t.Error(stats.CloseCodesOut, stats.CloseCodesIn)
+	}
+	after := GlobalStats()
+	if after.Connections-before.Connections != 2 || after.ActiveConnections != before.ActiveConnections {
+		t.Error(after.Connections, after.ActiveConnections)
+	}
+	if after.MessagesIn-before.MessagesIn != 4 || after.CloseCodesIn[CloseNormalClosure]-before.CloseCodesIn[CloseNormalClosure] != 2 {
+		t.Error(after.MessagesIn, after.CloseCodesIn)
+	}
+
+	w := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE websocket_frames_total counter\n",
+		"websocket_frames_total{direction=\"out\",opcode=\"text\"} ",
+		"websocket_close_codes_total{direction=\"in\",code=\"1000\"} ",
+		"# TYPE websocket_connections gauge\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Error(line)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// TraceInfo represents the timing of a traced step.
+type TraceInfo struct {
+	// Start is the time at which the step started.
+	Start time.Time
+	// Duration is how long the step took.
+	Duration time.Duration
+	// Err is the error of the step, if any.
+	Err error
+}
+
+// FrameInfo represents a frame that has been read or written.
+type FrameInfo struct {
+	// Opcode is the opcode of the frame.
+	Opcode int
+	// FIN reports whether the frame is the final fragment of a message.
+	FIN bool
+	// Length is the length of the payload.
+	Length int
+	// Time is the time at which the frame has been read or written.
+	Time time.Time
+	// Duration is how long the write took. It is zero for a frame read.
+	Duration time.Duration
+}
+
+// CloseInfo represents a close frame that has been sent or received.
+type CloseInfo struct {
+	// Code is the status code of the close frame.
+	Code int
+	// Text is the reason of the close frame.
+	Text string
+	// Time is the time at which the close frame has been sent or received.
+	Time time.Time
+}
+
+// ClientTrace is a set of hooks to run at various stages of a client
+// connection. Any particular hook may be nil. The hooks may be called
+// concurrently from different goroutines.
+type ClientTrace struct {
+	// GotConn is called after the network connection has been dialed,
+	// with a nil conn if the dial failed.
+	GotConn func(conn net.Conn, info TraceInfo)
+	// TLSHandshakeStart is called when the TLS handshake is started.
+	TLSHandshakeStart func()
+	// TLSHandshakeDone is called after the TLS handshake.
+	TLSHandshakeDone func(state tls.ConnectionState, info TraceInfo)
+	// WroteHandshake is called after the handshake request has been written.
+	WroteHandshake func(info TraceInfo)
+	// GotHandshakeResponse is called after the handshake response has been
+	// read, with a nil response if it could not be read.
+	GotHandshakeResponse func(resp *http.Response, info TraceInfo)
+	// FrameRead is called after a frame has been read.
+	FrameRead func(info FrameInfo)
+	// FrameWritten is called after a frame has been written.
+	FrameWritten func(info FrameInfo)
+	// CloseSent is called after a close frame has been sent.
+	CloseSent func(info CloseInfo)
+	// CloseReceived is called after a close frame has been received.
+	CloseReceived func(info CloseInfo)
+}
+
+// ServerTrace is a set of hooks to run at various stages of a server
+// connection. Any particular hook may be nil. The hooks may be called
+// concurrently from different goroutines.
+type ServerTrace struct {
+	// TLSHandshakeStart is called when the TLS handshake is started.
+	TLSHandshakeStart func()
+	// TLSHandshakeDone is called after the TLS handshake.
+	TLSHandshakeDone func(state tls.ConnectionState, info TraceInfo)
+	// GotHandshakeRequest is called when the handshake request is
+	// received. The duration is zero if the request has been read by
+	// the HTTP server.
+	GotHandshakeRequest func(r *http.Request, info TraceInfo)
+	// WroteHandshake is called after the handshake response has been written.
+	WroteHandshake func(info TraceInfo)
+	// FrameRead is called after a frame has been read.
+	FrameRead func(info FrameInfo)
+	// FrameWritten is called after a frame has been written.
+	FrameWritten func(info FrameInfo)
+	// CloseSent is called after a close frame has been sent.
+	CloseSent func(info CloseInfo)
+	// CloseReceived is called after a close frame has been received.
+	CloseReceived func(info CloseInfo)
+}
+
+type clientTraceKey struct{}
+
+type serverTraceKey struct{}
+
+// WithClientTrace returns a new context based on the provided parent ctx.
+// The connections dialed with the returned context use the provided trace hooks.
+func WithClientTrace(ctx context.Context, trace *ClientTrace) context.Context {
+	return context.WithValue(ctx, clientTraceKey{}, trace)
+}
+
+// ContextClientTrace returns the ClientTrace associated with the provided
+// context. If none, it returns nil.
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	trace, _ := ctx.Value(clientTraceKey{}).(*ClientTrace)
+	return trace
+}
+
+// WithServerTrace returns a new context based on the provided parent ctx.
+// The connections upgraded from a request with the returned context use
+// the provided trace hooks.
+func WithServerTrace(ctx context.Context, trace *ServerTrace) context.Context {
+	return context.WithValue(ctx, serverTraceKey{}, trace)
+}
+
+// ContextServerTrace returns the ServerTrace associated with the provided
+// context. If none, it returns nil.
+func ContextServerTrace(ctx context.Context) *ServerTrace {
+	trace, _ := ctx.Value(serverTraceKey{}).(*ServerTrace)
+	return trace
+}
+
+// connTrace holds the hooks that are run by a Conn.
+type connTrace struct {
+	WroteHandshake       func(info TraceInfo)
+	GotHandshakeResponse func(resp *http.Response, info TraceInfo)
+	FrameRead            func(info FrameInfo)
+	FrameWritten         func(info FrameInfo)
+	CloseSent            func(info CloseInfo)
+	CloseReceived        func(info CloseInfo)
+}
+
+func (t *ClientTrace) connTrace() *connTrace {
+	if t == nil {
+		return nil
+	}
+	return &connTrace{
+		WroteHandshake:       t.WroteHandshake,
+		GotHandshakeResponse: t.GotHandshakeResponse,
+		FrameRead:            t.FrameRead,
+		FrameWritten:         t.FrameWritten,
+		CloseSent:            t.CloseSent,
+		CloseReceived:        t.CloseReceived,
+	}
+}
+
+func (t *ServerTrace) connTrace() *connTrace {
+	if t == nil {
+		return nil
+	}
+	return &connTrace{
+		WroteHandshake: t.WroteHandshake,
+		FrameRead:      t.FrameRead,
+		FrameWritten:   t.FrameWritten,
+		CloseSent:      t.CloseSent,
+		CloseReceived:  t.CloseReceived,
+	}
+}
+
+// tlsHandshake runs the TLS handshake of conn with the trace hooks.
+func tlsHandshake(conn *tls.Conn, start func(), done func(tls.ConnectionState, TraceInfo)) error {
+	if start != nil {
+		start()
+	}
+	begin := time.Now()
+	err := conn.Handshake()
+	if done != nil {
+		done(conn.ConnectionState(), TraceInfo{Start: begin, Duration: time.Since(begin), Err: err})
+	}
+	return err
+}
+
+// tracer returns the trace hooks of the connection, or nil.
+func (c *Conn) tracer() *connTrace {
+	if x := c.loadExtra(); x != nil {
+		return x.trace
+	}
+	return nil
+}
+
+func (c *Conn) traceFrameRead(f *frame) {
+	if t := c.tracer(); t != nil && t.FrameRead != nil {
+		t.FrameRead(FrameInfo{Opcode: int(f.Opcode), FIN: f.FIN == 1, Length: len(f.PayloadData), Time: time.Now()})
+	}
+}
+
+func (c *Conn) traceFrameWritten(opcode byte, fin byte, length int, d time.Duration) {
+	if t := c.tracer(); t != nil && t.FrameWritten != nil {
+		t.FrameWritten(FrameInfo{Opcode: int(opcode), FIN: fin == 1, Length: length, Time: time.Now(), Duration: d})
+	}
+}
+
+func (c *Conn) traceCloseSent(code int, text string) {
+	if t := c.tracer(); t != nil && t.CloseSent != nil {
+		t.CloseSent(CloseInfo{Code: code, Text: text, Time: time.Now()})
+	}
+}
+
+func (c *Conn) traceCloseReceived(code int, text string) {
+	if t := c.tracer(); t != nil && t.CloseReceived != nil {
+		t.CloseReceived(CloseInfo{Code: code, Text: text, Time: time.Now()})
+	}
+}
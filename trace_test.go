@@ -0,0 +1,145 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	var lock sync.Mutex
+	var events []string
+	record := func(name string) {
+		lock.Lock()
+		events = append(events, name)
+		lock.Unlock()
+	}
+	event := func(name string, info TraceInfo) {
+		if info.Err != nil || info.Start.IsZero() || info.Duration < 0 {
+			t.Error(name, info)
+		}
+		record(name)
+	}
+	var serverFrames, clientFrames int
+	var closeSent, closeReceived []int
+	u := Upgrader{
+		TLSConfig: testServerTLSConfig(),
+		Trace: &ServerTrace{
+			TLSHandshakeDone: func(state tls.ConnectionState, info TraceInfo) {
+				event("server TLSHandshakeDone", info)
+			},
+			GotHandshakeRequest: func(r *http.Request, info TraceInfo) {
+				event("server GotHandshakeRequest", info)
+			},
+			WroteHandshake: func(info TraceInfo) {
+				event("server WroteHandshake", info)
+			},
+			FrameRead: func(info FrameInfo) {
+				serverFrames++
+			},
+		},
+	}
+	l, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ws, err := u.Upgrade(conn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for {
+			msg, err := ws.ReadMessage(nil)
+			if err != nil {
+				break
+			}
+			ws.WriteMessage(msg)
+		}
+		ws.Close()
+	}()
+	trace := &ClientTrace{
+		GotConn: func(conn net.Conn, info TraceInfo) {
+			event("GotConn", info)
+		},
+		TLSHandshakeStart: func() {
+			record("TLSHandshakeStart")
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, info TraceInfo) {
+			event("TLSHandshakeDone", info)
+		},
+		WroteHandshake: func(info TraceInfo) {
+			event("WroteHandshake", info)
+		},
+		GotHandshakeResponse: func(resp *http.Response, info TraceInfo) {
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				t.Error(resp.Status)
+			}
+			event("GotHandshakeResponse", info)
+		},
+		FrameWritten: func(info FrameInfo) {
+			if info.Opcode == BinaryFrame && (!info.FIN || info.Length != 5 || info.Time.IsZero()) {
+				t.Error(info)
+			}
+			clientFrames++
+		},
+		CloseSent: func(info CloseInfo) {
+			closeSent = append(closeSent, info.Code)
+		},
+		CloseReceived: func(info CloseInfo) {
+			closeReceived = append(closeReceived, info.Code)
+		},
+	}
+	d := Dialer{TLSConfig: testClientTLSConfig()}
+	conn, err := d.DialContext(WithClientTrace(context.Background(), trace), "tcp", ":8080", "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage([]byte("Hello")); err != nil {
+		t.Error(err)
+	}
+	if _, err := conn.ReadMessage(nil); err != nil {
+		t.Error(err)
+	}
+	conn.writeClose(CloseNormalClosure, "")
+	conn.ReadMessage(nil)
+	conn.Close()
+	l.Close()
+	wg.Wait()
+	expect := []string{
+		"GotConn", "TLSHandshakeStart", "server TLSHandshakeDone", "TLSHandshakeDone",
+		"WroteHandshake", "server GotHandshakeRequest", "server WroteHandshake", "GotHandshakeResponse",
+	}
+	for _, name := range expect {
+		var found bool
+		for _, e := range events {
+			found = found || e == name
+		}
+		if !found {
+			t.Error(name, events)
+		}
+	}
+	if clientFrames != 2 || serverFrames != 2 {
+		t.Error(clientFrames, serverFrames)
+	}
+	if len(closeSent) != 1 || closeSent[0] != CloseNormalClosure {
+		t.Error(closeSent)
+	}
+	if len(closeReceived) != 1 || closeReceived[0] != CloseNormalClosure {
+		t.Error(closeReceived)
+	}
+}
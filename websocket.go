@@ -6,10 +6,13 @@ package websocket
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/http"
 	"sync"
@@ -20,66 +23,253 @@ var responsePool = &sync.Pool{New: func() interface{} {
 	return make([]byte, 1024)
 }}
 
+// Upgrader specifies parameters for upgrading a connection to the WebSocket protocol.
+type Upgrader struct {
+	// TLSConfig optionally provides a TLS configuration for Upgrade.
+	TLSConfig *tls.Config
+	// BufferPool is used for the read and write buffers of the upgraded
+	// connections. If nil, DefaultBufferPool is used.
+	BufferPool BufferPool
+	// Trace optionally provides the trace hooks of the upgraded connections.
+	// A ServerTrace attached to the context of the request takes precedence.
+	Trace *ServerTrace
+	// Logger optionally logs the rejected upgrades, and the events of the
+	// upgraded connections. If nil, the logger set by SetLogger is used.
+	Logger Logger
+	// HandshakeTimeout specifies the duration for the handshake to complete,
+	// including the TLS handshake and the read of the request by Upgrade.
+	// If zero, there is no timeout.
+	HandshakeTimeout time.Duration
+	// MaxHeaderBytes limits the size of the request line and the headers
+	// read by Upgrade and by a Handshaker. If zero, http.DefaultMaxHeaderBytes
+	// is used.
+	MaxHeaderBytes int
+	// MaxMessageSize optionally limits the size of the messages read from the
+	// upgraded connections, which can be changed with SetReadLimit. If zero,
+	// the size of the messages is not limited.
+	MaxMessageSize int
+	// Subprotocols specifies the subprotocols supported by the server, in
+	// order of preference. The first one requested by the client is
+	// negotiated.
+	Subprotocols []string
+	// Authenticate optionally authenticates the handshake request. If the
+	// returned status is neither zero nor 101, the upgrade is rejected with
+	// the status and the header, such as a WWW-Authenticate field. Otherwise
+	// the header is added to the 101 response, such as Set-Cookie fields,
+	// and the principal is attached to the connection. BearerToken can be
+	// used to find the token of the request.
+	Authenticate func(r *http.Request) (principal interface{}, status int, header http.Header)
+}
+
 // UpgradeHTTP upgrades the HTTP server connection to the WebSocket protocol.
 func UpgradeHTTP(w http.ResponseWriter, r *http.Request) (*Conn, error) {
-	return upgradeHTTP(w, r, false)
+	var u Upgrader
+	return u.UpgradeHTTP(w, r)
+}
+
+// UpgradeHTTP upgrades the HTTP server connection to the WebSocket protocol.
+func (u *Upgrader) UpgradeHTTP(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	return u.upgrade(w, r, TraceInfo{Start: time.Now()})
 }
 
-func upgradeHTTP(w http.ResponseWriter, r *http.Request, shared bool) (*Conn, error) {
+func (u *Upgrader) upgrade(w http.ResponseWriter, r *http.Request, info TraceInfo) (*Conn, error) {
+	trace := ContextServerTrace(r.Context())
+	if trace == nil {
+		trace = u.Trace
+	}
+	if trace != nil && trace.GotHandshakeRequest != nil {
+		trace.GotHandshakeRequest(r, info)
+	}
 	if r.Method != "GET" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		io.WriteString(w, "405 must GET\n")
-		return nil, errors.New("405 must GET")
+		return nil, u.reject(r.RemoteAddr, errors.New("405 must GET"))
 	}
 	if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Connection") != "Upgrade" {
 		w.WriteHeader(http.StatusBadRequest)
 		io.WriteString(w, "400 not websocket protocol\n")
-		return nil, errors.New("400 not websocket protocol")
+		return nil, u.reject(r.RemoteAddr, errors.New("400 not websocket protocol"))
 	}
 	key := r.Header.Get("Sec-WebSocket-Key")
 	if key == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		io.WriteString(w, "400 bad Key\n")
-		return nil, errors.New("400 bad Key")
+		return nil, u.reject(r.RemoteAddr, errors.New("400 bad Key"))
+	}
+	var principal interface{}
+	var header http.Header
+	if u.Authenticate != nil {
+		var status int
+		principal, status, header = u.Authenticate(r)
+		if status != 0 && status != http.StatusSwitchingProtocols {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			text := fmt.Sprintf("%03d %s", status, http.StatusText(status))
+			w.WriteHeader(status)
+			io.WriteString(w, text+"\n")
+			return nil, u.reject(r.RemoteAddr, errors.New(text))
+		}
 	}
 	netConn, _, err := w.(http.Hijacker).Hijack()
 	if err == nil {
-		conn := server(netConn, shared, key)
+		conn := server(netConn, key)
+		if u.BufferPool != nil {
+			conn.pool = u.BufferPool
+		}
+		if trace != nil || u.Logger != nil || principal != nil || len(header) > 0 {
+			x := conn.getExtra()
+			x.trace = trace.connTrace()
+			x.logger = u.Logger
+			x.principal = principal
+			x.responseHeader = header
+		}
+		conn.maxMessageSize = u.MaxMessageSize
+		if _, ok := w.(*response); ok {
+			// The request has been read from the connection by Upgrade, a
+			// ServeMux or a Handshaker, so it is not shared.
+			r.Body = http.NoBody
+			conn.request = r
+		} else {
+			conn.request = copyRequest(r)
+		}
+		conn.subprotocol = u.selectSubprotocol(r)
+		if u.HandshakeTimeout > 0 {
+			netConn.SetDeadline(time.Now().Add(u.HandshakeTimeout))
+		}
 		err = conn.handshake()
 		if err == nil {
+			if u.HandshakeTimeout > 0 {
+				netConn.SetDeadline(time.Time{})
+			}
 			return conn, nil
 		}
-	} else if netConn != nil {
-		netConn.Close()
+		conn.Close()
+		if l := u.log(); l != nil {
+			l.Info("upgrade failed", "error", err, "remote", r.RemoteAddr)
+		}
+	} else {
+		if netConn != nil {
+			netConn.Close()
+		}
+		if l := u.log(); l != nil {
+			l.Error("hijack failed", "error", err, "remote", r.RemoteAddr)
+		}
 	}
 	return nil, err
+}
 
+// reject logs the rejected upgrade and returns the reason.
+func (u *Upgrader) reject(remote string, reason error) error {
+	if l := u.log(); l != nil {
+		l.Info("upgrade rejected", "reason", reason, "remote", remote)
+	}
+	return reason
 }
 
 // Upgrade upgrades the net.Conn conn to the WebSocket protocol.
 func Upgrade(conn net.Conn, config *tls.Config) (*Conn, error) {
-	if config != nil {
-		tlsConn := tls.Server(conn, config)
-		if err := tlsConn.Handshake(); err != nil {
-			conn.Close()
-			return nil, err
+	u := Upgrader{TLSConfig: config}
+	return u.Upgrade(conn)
+}
+
+// Upgrade upgrades the net.Conn conn to the WebSocket protocol.
+func (u *Upgrader) Upgrade(conn net.Conn) (*Conn, error) {
+	conn, err := u.serverTLS(conn)
+	if err != nil {
+		return nil, err
+	}
+	r := u.newRequestReader(conn)
+	req, begin, err := r.read()
+	if err != nil {
+		return nil, u.reject(remoteAddr(conn), err)
+	}
+	return r.upgrade(req, begin)
+}
+
+// serverTLS sets the handshake deadline of conn, and runs the TLS handshake
+// if the upgrader has a TLS configuration.
+func (u *Upgrader) serverTLS(conn net.Conn) (net.Conn, error) {
+	if u.HandshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(u.HandshakeTimeout))
+	}
+	if u.TLSConfig == nil {
+		return conn, nil
+	}
+	tlsConn := tls.Server(conn, u.TLSConfig)
+	var start func()
+	var done func(tls.ConnectionState, TraceInfo)
+	if u.Trace != nil {
+		start, done = u.Trace.TLSHandshakeStart, u.Trace.TLSHandshakeDone
+	}
+	if err := tlsHandshake(tlsConn, start, done); err != nil {
+		conn.Close()
+		return nil, u.reject(remoteAddr(conn), err)
+	}
+	return tlsConn, nil
+}
+
+// requestReader reads the HTTP requests of a raw connection.
+type requestReader struct {
+	upgrader *Upgrader
+	conn     net.Conn
+	limit    *io.LimitedReader
+	reader   *bufio.Reader
+}
+
+func (u *Upgrader) newRequestReader(conn net.Conn) *requestReader {
+	limit := &io.LimitedReader{R: conn}
+	return &requestReader{upgrader: u, conn: conn, limit: limit, reader: bufio.NewReader(limit)}
+}
+
+// read reads a request with a limited size, and sets its remote address.
+// On error, the connection has been closed.
+func (r *requestReader) read() (req *http.Request, begin time.Time, err error) {
+	// Like net/http, allow the bufio.Reader to read ahead beyond the limit.
+	r.limit.N = int64(r.upgrader.maxHeaderBytes()) + 4096
+	begin = time.Now()
+	req, err = http.ReadRequest(r.reader)
+	if err != nil {
+		if r.limit.N <= 0 {
+			err = ErrRequestTooLarge
+			res := &response{conn: r.conn, status: http.StatusRequestHeaderFieldsTooLarge}
+			io.WriteString(res, "431 request too large\n")
 		}
-		conn = tlsConn
+		r.conn.Close()
+		return nil, begin, err
 	}
-	var b = bufio.NewReader(conn)
-	req, err := http.ReadRequest(b)
+	r.limit.N = math.MaxInt64
+	req.RemoteAddr = remoteAddr(r.conn)
+	return req, begin, nil
+}
+
+// upgrade upgrades the connection with the request. The bytes that have been
+// read after the request are kept by the upgraded connection. On error, the
+// connection has been closed.
+func (r *requestReader) upgrade(req *http.Request, begin time.Time) (*Conn, error) {
+	res := &response{conn: r.conn}
+	ws, err := r.upgrader.upgrade(res, req, TraceInfo{Start: begin, Duration: time.Since(begin)})
 	if err != nil {
+		r.conn.Close()
 		return nil, err
 	}
-	res := &response{handlerHeader: req.Header, conn: conn}
-	return upgradeHTTP(res, req, true)
+	p, _ := r.reader.Peek(r.reader.Buffered())
+	ws.buffered(p)
+	return ws, nil
+}
+
+func (u *Upgrader) maxHeaderBytes() int {
+	if u.MaxHeaderBytes > 0 {
+		return u.MaxHeaderBytes
+	}
+	return http.DefaultMaxHeaderBytes
 }
 
 type response struct {
-	handlerHeader http.Header
-	status        int
-	conn          net.Conn
+	header http.Header
+	status int
+	conn   net.Conn
 }
 
 func (w *response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
@@ -87,7 +277,10 @@ func (w *response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 }
 
 func (w *response) Header() http.Header {
-	return w.handlerHeader
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
 }
 
 func (w *response) Write(data []byte) (n int, err error) {
@@ -96,6 +289,11 @@ func (w *response) Write(data []byte) (n int, err error) {
 	h = append(h, fmt.Sprintf("Date: %s\r\n", time.Now().UTC().Format(http.TimeFormat))...)
 	h = append(h, fmt.Sprintf("Content-Length: %d\r\n", len(data))...)
 	h = append(h, "Content-Type: text/plain; charset=utf-8\r\n"...)
+	if len(w.header) > 0 {
+		var b bytes.Buffer
+		w.header.WriteSubset(&b, responseExclude)
+		h = append(h, b.Bytes()...)
+	}
 	h = append(h, "\r\n"...)
 	h = append(h, data...)
 	n, err = w.conn.Write(h)
@@ -103,6 +301,18 @@ func (w *response) Write(data []byte) (n int, err error) {
 	return len(data), err
 }
 
+// responseExclude are the header fields that are written by the response
+// itself, and the handshake.
+var responseExclude = map[string]bool{
+	"Date":                   true,
+	"Content-Length":         true,
+	"Content-Type":           true,
+	"Upgrade":                true,
+	"Connection":             true,
+	"Sec-Websocket-Accept":   true,
+	"Sec-Websocket-Protocol": true,
+}
+
 func (w *response) WriteHeader(code int) {
 	w.status = code
 }
@@ -115,26 +325,100 @@ func parseHost(address string) string {
 	return serverName
 }
 
+// Dialer contains options for connecting to a WebSocket server.
+type Dialer struct {
+	// TLSConfig optionally provides a TLS configuration for the client.
+	TLSConfig *tls.Config
+	// BufferPool is used for the read and write buffers of the dialed
+	// connections. If nil, DefaultBufferPool is used.
+	BufferPool BufferPool
+	// Trace optionally provides the trace hooks of the dialed connections.
+	// A ClientTrace attached to the context of DialContext takes precedence.
+	Trace *ClientTrace
+	// Logger optionally logs the events of the dialed connections. If nil,
+	// the logger set by SetLogger is used.
+	Logger Logger
+	// MaxMessageSize optionally limits the size of the messages read from the
+	// dialed connections, which can be changed with SetReadLimit. If zero,
+	// the size of the messages is not limited.
+	MaxMessageSize int
+	// Subprotocols specifies the subprotocols requested by the client, in
+	// order of preference.
+	Subprotocols []string
+}
+
 // Dial opens a new client connection to a WebSocket.
 func Dial(network, address, path string, config *tls.Config) (*Conn, error) {
-	var err error
-	netConn, err := net.Dial(network, address)
+	d := Dialer{TLSConfig: config}
+	return d.Dial(network, address, path)
+}
+
+// Dial opens a new client connection to a WebSocket.
+func (d *Dialer) Dial(network, address, path string) (*Conn, error) {
+	return d.DialContext(context.Background(), network, address, path)
+}
+
+// DialContext opens a new client connection to a WebSocket using the
+// provided context. The deadline and the cancellation of the context bound
+// the dial, the TLS handshake and the WebSocket handshake, but not the
+// connection once established. The values of the context are kept by the
+// context of the connection.
+func (d *Dialer) DialContext(ctx context.Context, network, address, path string) (*Conn, error) {
+	trace := ContextClientTrace(ctx)
+	if trace == nil {
+		trace = d.Trace
+	}
+	var dialer net.Dialer
+	start := time.Now()
+	netConn, err := dialer.DialContext(ctx, network, address)
+	if trace != nil && trace.GotConn != nil {
+		trace.GotConn(netConn, TraceInfo{Start: start, Duration: time.Since(start), Err: err})
+	}
 	if err != nil {
 		return nil, err
 	}
-	if config != nil {
+	plainConn := netConn
+	if deadline, ok := ctx.Deadline(); ok {
+		plainConn.SetDeadline(deadline)
+	}
+	stop := interrupt(ctx, plainConn)
+	if config := d.TLSConfig; config != nil {
 		if config.ServerName == "" {
 			config.ServerName = parseHost(address)
 		}
 		tlsConn := tls.Client(netConn, config)
-		if err = tlsConn.Handshake(); err != nil {
+		var start func()
+		var done func(tls.ConnectionState, TraceInfo)
+		if trace != nil {
+			start, done = trace.TLSHandshakeStart, trace.TLSHandshakeDone
+		}
+		if err = tlsHandshake(tlsConn, start, done); err != nil {
+			stop()
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
 			tlsConn.Close()
 			return nil, err
 		}
 		netConn = tlsConn
 	}
-	conn := client(netConn, false, address, path)
+	conn := client(netConn, address, path)
+	if d.BufferPool != nil {
+		conn.pool = d.BufferPool
+	}
+	if trace != nil || d.Logger != nil || len(d.Subprotocols) > 0 || ctx != context.Background() {
+		x := conn.getExtra()
+		x.trace = trace.connTrace()
+		x.logger = d.Logger
+		x.subprotocols = d.Subprotocols
+		x.parent = valuesContext{ctx}
+	}
+	conn.maxMessageSize = d.MaxMessageSize
 	err = conn.handshake()
+	stop()
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
 	if err != nil {
 		conn.Close()
 		return nil, &net.OpError{
@@ -144,13 +428,37 @@ func Dial(network, address, path string, config *tls.Config) (*Conn, error) {
 			Err:  err,
 		}
 	}
+	plainConn.SetDeadline(time.Time{})
 	return conn, nil
 }
 
+// interrupt interrupts the reads and the writes of conn when ctx is done, by
+// setting a deadline in the past. The returned function stops watching.
+func interrupt(ctx context.Context, conn net.Conn) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
 // Handler represents a http.Handler.
 type Handler func(*Conn)
 
-// ServeHTTP implements the http.Handler interface for a WebSocket
+// ServeHTTP implements the http.Handler interface for a WebSocket.
+// Rejected upgrades are logged by the logger set by SetLogger.
 func (handler Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	conn, err := UpgradeHTTP(w, r)
 	if err == nil {
@@ -5,6 +5,7 @@ package websocket
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -286,7 +287,7 @@ func TestUpgradeHTTP(t *testing.T) {
 				}
 				netConn = tlsConn
 			}
-			conn := client(netConn, true, address, path)
+			conn := client(netConn, address, path)
 			conn.SetBufferedInput(bufferSize)
 			conn.SetBufferedOutput(bufferSize)
 			err = clientHandshake(conn)
@@ -359,7 +360,7 @@ func TestUpgradeHTTP(t *testing.T) {
 				}
 				netConn = tlsConn
 			}
-			conn := client(netConn, false, address, path)
+			conn := client(netConn, address, path)
 			err = clientHandshake(conn)
 			if err != nil {
 				conn.Close()
@@ -417,7 +418,7 @@ func TestUpgradeHTTP(t *testing.T) {
 				}
 				netConn = tlsConn
 			}
-			conn := client(netConn, false, address, path)
+			conn := client(netConn, address, path)
 			err = clientHandshake(conn)
 			if err != nil {
 				conn.Close()
@@ -477,7 +478,7 @@ func TestUpgradeHTTP(t *testing.T) {
 				}
 				netConn = tlsConn
 			}
-			conn := client(netConn, false, address, path)
+			conn := client(netConn, address, path)
 			err = clientHandshake(conn)
 			if err != nil {
 				conn.Close()
@@ -537,7 +538,7 @@ func TestUpgradeHTTP(t *testing.T) {
 				}
 				netConn = tlsConn
 			}
-			conn := client(netConn, false, address, path)
+			conn := client(netConn, address, path)
 			conn.Close()
 			err = clientHandshake(conn)
 			if err != nil {
@@ -618,7 +619,8 @@ func TestResponseWriter(t *testing.T) {
 			return nil, err
 		}
 		res := &testResponse{handlerHeader: req.Header, conn: conn}
-		return upgradeHTTP(res, req, true)
+		var u Upgrader
+		return u.UpgradeHTTP(res, req)
 	}
 
 	l, _ := net.Listen(network, addr)
@@ -653,3 +655,72 @@ func TestResponseWriter(t *testing.T) {
 	l.Close()
 	wg.Wait()
 }
+
+func TestDialContextTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The server accepts the connections, but never answers.
+	var conns []net.Conn
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	for _, config := range []*tls.Config{nil, testSkipVerifyTLSConfig()} {
+		d := Dialer{TLSConfig: config}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		start := time.Now()
+		if _, err := d.DialContext(ctx, "tcp", ":8080", "/"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Error(err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Error(elapsed)
+		}
+		cancel()
+		ctx, cancel = context.WithCancel(context.Background())
+		time.AfterFunc(time.Millisecond*100, cancel)
+		if _, err := d.DialContext(ctx, "tcp", ":8080", "/"); !errors.Is(err, context.Canceled) {
+			t.Error(err)
+		}
+	}
+	l.Close()
+	wg.Wait()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func TestDialContextDeadline(t *testing.T) {
+	errs := make(chan error, 1)
+	l, wg := testServe(t, nil, errs)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	var d Dialer
+	conn, err := d.DialContext(ctx, "tcp", ":8080", "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The deadline of the context does not apply to the connection.
+	<-ctx.Done()
+	if err := conn.WriteMessage([]byte("Hello World")); err != nil {
+		t.Error(err)
+	}
+	if msg, err := conn.ReadMessage(nil); err != nil {
+		t.Error(err)
+	} else if string(msg) != "Hello World" {
+		t.Error(string(msg))
+	}
+	conn.Close()
+	<-errs
+	l.Close()
+	wg.Wait()
+}